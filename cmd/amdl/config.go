@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"main/internal/structs"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// runConfig implements `amdl config show|path`.
+func runConfig(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config show|path\n", "amdl")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	action := "show"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+	switch action {
+	case "show":
+		shown := *cfg
+		if shown.MediaUserToken != "" {
+			shown.MediaUserToken = "(set)"
+		}
+		if shown.AuthorizationToken != "" {
+			shown.AuthorizationToken = "(set)"
+		}
+		data, err := yaml.Marshal(shown)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(data))
+	case "path":
+		path, err := filepath.Abs("config.yaml")
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown config action: %s", action)
+	}
+	return nil
+}
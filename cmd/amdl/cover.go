@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"main/internal/api"
+	"main/internal/structs"
+	"main/internal/tagger"
+	"main/internal/utils"
+
+	"github.com/spf13/pflag"
+)
+
+// runCover implements `amdl cover`.
+func runCover(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("cover", pflag.ContinueOnError)
+	outDir := fs.StringP("out", "o", ".", "Folder to save the cover in")
+	name := fs.String("name", "cover", "File name of the cover, without extension")
+	fs.StringVar(&cfg.CoverSize, "size", cfg.CoverSize, "Cover size, e.g. 5000x5000")
+	fs.StringVar(&cfg.CoverFormat, "format", cfg.CoverFormat, "Cover format: jpg, png or original")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s cover [options] <album|playlist|song url>...\n", "amdl")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no URLs provided")
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(*outDir, os.ModePerm)
+
+	for i, rawUrl := range fs.Args() {
+		var artwork string
+		switch {
+		case strings.Contains(rawUrl, "/album/"):
+			storefront, albumId := utils.CheckUrl(rawUrl)
+			resp, err := api.GetAlbumResp(storefront, albumId, cfg.Language, token)
+			if err != nil {
+				return err
+			}
+			artwork = resp.Data[0].Attributes.Artwork.URL
+		case strings.Contains(rawUrl, "/playlist/"):
+			storefront, playlistId := utils.CheckUrlPlaylist(rawUrl)
+			resp, err := api.GetPlaylistResp(storefront, playlistId, cfg.Language, token)
+			if err != nil {
+				return err
+			}
+			artwork = resp.Data[0].Attributes.Artwork.URL
+		case strings.Contains(rawUrl, "/song/"):
+			storefront, songId := utils.CheckUrlSong(rawUrl)
+			resp, err := api.GetSongResp(storefront, songId, cfg.Language, token)
+			if err != nil {
+				return err
+			}
+			artwork = resp.Data[0].Attributes.Artwork.URL
+		default:
+			fmt.Println("Unsupported URL:", rawUrl)
+			continue
+		}
+		fileName := *name
+		if fs.NArg() > 1 {
+			fileName = fmt.Sprintf("%s-%d", *name, i+1)
+		}
+		path, err := tagger.WriteCover(*outDir, fileName, artwork, cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	return nil
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"main/internal/api"
+	"main/internal/downloader"
+	"main/internal/history"
+	"main/internal/lyrics"
+	"main/internal/naming"
+	"main/internal/queue"
+	"main/internal/report"
+	"main/internal/structs"
+	"main/internal/ui"
+	"main/internal/utils"
+
+	"github.com/spf13/pflag"
+)
+
+// addDownloadFlags registers the flags shared by get, search and resume.
+func addDownloadFlags(fs *pflag.FlagSet, cfg *structs.ConfigSet) {
+	fs.BoolVar(&dl_atmos, "atmos", false, "Enable atmos download mode")
+	fs.BoolVar(&dl_aac, "aac", false, "Enable adm-aac download mode")
+	fs.BoolVar(&dl_select, "select", false, "Enable selective download")
+	fs.BoolVar(&artist_select, "all-album", false, "Download all albums and music videos of an artist (same as --albums all --mvs all)")
+	alac_max = fs.Int("alac-max", cfg.AlacMax, "Specify the max quality for download alac")
+	atmos_max = fs.Int("atmos-max", cfg.AtmosMax, "Specify the max quality for download atmos")
+	aac_type = fs.String("aac-type", cfg.AacType, "Select AAC type, aac aac-binaural aac-downmix")
+	mv_audio_type = fs.String("mv-audio-type", cfg.MVAudioType, "Select MV audio type, atmos ac3 aac")
+	mv_max = fs.Int("mv-max", cfg.MVMax, "Specify the max quality for download MV")
+	jobs = fs.Int("jobs", cfg.Jobs, "Number of tracks to download in parallel")
+	fs.BoolVar(&non_interactive, "non-interactive", false, "Never prompt; take selections from --tracks, --albums, --mvs and --pick")
+	fs.StringVar(&tracks_select, "tracks", "", "Tracks to download from an album or playlist, e.g. 1-3,7 or all (implies --select)")
+	fs.StringVar(&albums_select, "albums", "", "Albums to download from an artist, e.g. 1-3,7, all or none")
+	fs.StringVar(&mvs_select, "mvs", "", "Music videos to download from an artist, e.g. 1-3,7, all or none")
+	fs.StringVar(&output_format, "output", "text", "Progress output: text, or json for one event per track on stdout")
+	fs.StringVar(&report_file, "report", cfg.ReportFile, "Write a JSON report of every track to this file when the run ends")
+	fs.BoolVar(&dry_run, "dry-run", false, "Print the folders and files a download would write without downloading")
+	fs.BoolVar(&refresh_lyrics, "refresh-lyrics", false, "Fetch lyrics again instead of using the lyrics cache")
+}
+
+// applyDownloadFlags copies the parsed download flags into cfg.
+func applyDownloadFlags(cfg *structs.ConfigSet) error {
+	cfg.AlacMax = *alac_max
+	cfg.AtmosMax = *atmos_max
+	cfg.AacType = *aac_type
+	cfg.MVAudioType = *mv_audio_type
+	cfg.MVMax = *mv_max
+	cfg.Jobs = *jobs
+	if tracks_select != "" {
+		dl_select = true
+	}
+	if artist_select {
+		if albums_select == "" {
+			albums_select = "all"
+		}
+		if mvs_select == "" {
+			mvs_select = "all"
+		}
+	}
+	for name, format := range map[string]string{
+		"artist-folder-format":   cfg.ArtistFolderFormat,
+		"album-folder-format":    cfg.AlbumFolderFormat,
+		"playlist-folder-format": cfg.PlaylistFolderFormat,
+		"song-file-format":       cfg.SongFileFormat,
+		"disc-folder-format":     cfg.DiscFolderFormat,
+	} {
+		if err := naming.Check(format); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	downloader.DryRun = dry_run
+	lyrics.Refresh = refresh_lyrics
+	return report.Configure(output_format, report_file)
+}
+
+// runGet implements `amdl get`, which is also what a bare `amdl <url>` runs.
+func runGet(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("get", pflag.ContinueOnError)
+	addDownloadFlags(fs, cfg)
+	fs.StringVar(&input_file, "input-file", "", "Read URLs from a file, one per line with optional codec=, tracks=, albums=, mvs= overrides")
+	// flags of the flat CLI, kept so existing scripts keep working
+	var search_type string
+	var debug_mode, dl_song bool
+	fs.StringVar(&search_type, "search", "", "Search for 'album', 'song', or 'artist'")
+	fs.IntVar(&search_pick, "search-pick", 0, "Take the Nth search result without prompting")
+	fs.BoolVar(&debug_mode, "debug", false, "Show audio quality information")
+	fs.BoolVar(&dl_song, "song", false, "Single song download mode")
+	fs.MarkDeprecated("search", "use `amdl search` instead")
+	fs.MarkDeprecated("search-pick", "use `amdl search --pick` instead")
+	fs.MarkDeprecated("debug", "use `amdl info` instead")
+	fs.MarkDeprecated("song", "song URLs and album URLs with ?i= always download a single track")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s get [options] <url|-> ...\n", "amdl")
+		fmt.Fprintf(os.Stderr, "       %s get [options] --input-file urls.txt\n", "amdl")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if debug_mode {
+		return runInfo(cfg, append([]string{"--variants"}, fs.Args()...))
+	}
+	if search_type != "" {
+		return search(cfg, search_type, fs.Args())
+	}
+	if err := applyDownloadFlags(cfg); err != nil {
+		return err
+	}
+
+	targets, err := collectTargets(fs.Args(), input_file)
+	if err != nil {
+		return fmt.Errorf("failed to read URL list: %w", err)
+	}
+	if len(targets) == 0 {
+		fs.Usage()
+		return fmt.Errorf("no URLs provided")
+	}
+	return download(cfg, targets, nil)
+}
+
+// runSearch implements `amdl search`.
+func runSearch(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("search", pflag.ContinueOnError)
+	addDownloadFlags(fs, cfg)
+	fs.IntVar(&search_pick, "pick", 0, "Take the Nth result without prompting")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s search [options] <album|song|artist> <query>...\n", "amdl")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return fmt.Errorf("search needs a type and a query")
+	}
+	return search(cfg, fs.Arg(0), fs.Args()[1:])
+}
+
+func search(cfg *structs.ConfigSet, searchType string, query []string) error {
+	if err := applyDownloadFlags(cfg); err != nil {
+		return err
+	}
+	if len(query) == 0 {
+		return fmt.Errorf("search requires a query")
+	}
+	if non_interactive && search_pick < 1 {
+		return fmt.Errorf("search needs --pick in non-interactive mode")
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+	selected, err := ui.HandleSearch(searchType, query, token, cfg.Storefront, cfg.Language, search_pick)
+	if err != nil {
+		return fmt.Errorf("search process failed: %w", err)
+	}
+	if selected == nil {
+		fmt.Fprintln(report.Out, "\nExiting.")
+		if non_interactive {
+			return fmt.Errorf("nothing selected")
+		}
+		return nil
+	}
+	// the quality picked in the prompt wins over the download flags
+	t := target{URL: selected.URL, Codec: selected.Quality}
+	if selected.AACType != "" {
+		cfg.AacType = selected.AACType
+	}
+	if selected.AlacMax != 0 {
+		cfg.AlacMax = selected.AlacMax
+	}
+	return download(cfg, []target{t}, nil)
+}
+
+// runResume implements `amdl resume`.
+func runResume(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("resume", pflag.ContinueOnError)
+	addDownloadFlags(fs, cfg)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s resume [options] [manifest]\n", "amdl")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if err := applyDownloadFlags(cfg); err != nil {
+		return err
+	}
+	manifestPath := queue.DefaultPath(cfg)
+	if fs.NArg() > 0 {
+		manifestPath = fs.Arg(0)
+	}
+	job, err := queue.Load(manifestPath)
+	if err != nil {
+		return fmt.Errorf("load job manifest failed: %w", err)
+	}
+	dl_atmos = job.Options.Atmos
+	dl_aac = job.Options.AAC
+	dl_select = false
+	cfg.AacType = job.Options.AacType
+	cfg.AlacMax = job.Options.AlacMax
+	cfg.AtmosMax = job.Options.AtmosMax
+	cfg.MVAudioType = job.Options.MVAudioType
+	cfg.MVMax = job.Options.MVMax
+	var targets []target
+	for _, item := range job.Remaining() {
+		targets = append(targets, target{URL: item.URL, Codec: item.Codec, ArtistName: item.ArtistName, ArtistID: item.ArtistID})
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(report.Out, "Nothing to resume.")
+		return nil
+	}
+	fmt.Fprintf(report.Out, "Resuming %d items from %s\n", len(targets), job.Path())
+	return download(cfg, targets, job)
+}
+
+// download expands artists, writes the job manifest unless job is given and
+// rips every target.
+func download(cfg *structs.ConfigSet, targets []target, job *queue.Manifest) error {
+	if non_interactive {
+		if err := checkNonInteractive(targets); err != nil {
+			return err
+		}
+	}
+	hist, err := history.Open(history.DefaultPath(cfg))
+	if err != nil {
+		return fmt.Errorf("load history failed: %w", err)
+	}
+	if !dry_run {
+		if n := utils.RemoveStaleParts(utils.StalePartAge, cfg.AlacSaveFolder, cfg.AtmosSaveFolder, cfg.AacSaveFolder); n > 0 {
+			fmt.Fprintf(report.Out, "Removed %d unfinished %s files of an earlier run.\n", n, utils.PartExt)
+		}
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Handle /artist/ URL specifically (expands to albums/MVs)
+	finalTargets := []target{}
+	// artists that could not be expanded; retrying the queue cannot fix them
+	expandErrors := 0
+	for _, t := range targets {
+		rawUrl := t.URL
+		if strings.Contains(rawUrl, "/artist/") {
+			urlArtistName, urlArtistID, err := api.GetUrlArtistName(rawUrl, token, cfg.Language)
+			if err != nil {
+				fmt.Fprintln(report.Out, "Failed to get artistname.")
+				expandErrors++
+				continue
+			}
+
+			// Fetch Albums
+			albumUrls, err := api.FetchArtistItems(rawUrl, token, "albums", cfg.Language)
+			if err != nil {
+				fmt.Fprintln(report.Out, "Failed to fetch albums.")
+				expandErrors++
+			} else {
+				// Select
+				spec := albums_select
+				if t.Albums != "" {
+					spec = t.Albums
+				}
+				selected, err := ui.SelectArtistItems(albumUrls, "albums", spec)
+				if err != nil {
+					return fmt.Errorf("invalid albums selection: %w", err)
+				}
+				for _, u := range selected {
+					finalTargets = append(finalTargets, target{URL: u, Codec: t.Codec, ArtistName: urlArtistName, ArtistID: urlArtistID})
+				}
+			}
+
+			// Fetch MVs
+			mvUrls, err := api.FetchArtistItems(rawUrl, token, "music-videos", cfg.Language)
+			if err != nil {
+				fmt.Fprintln(report.Out, "Failed to fetch MVs.")
+				expandErrors++
+			} else {
+				// Select
+				spec := mvs_select
+				if t.MVs != "" {
+					spec = t.MVs
+				}
+				selected, err := ui.SelectArtistItems(mvUrls, "music-videos", spec)
+				if err != nil {
+					return fmt.Errorf("invalid music video selection: %w", err)
+				}
+				for _, u := range selected {
+					finalTargets = append(finalTargets, target{URL: u, Codec: t.Codec, ArtistName: urlArtistName, ArtistID: urlArtistID})
+				}
+			}
+		} else {
+			finalTargets = append(finalTargets, t)
+		}
+	}
+
+	// Write the job manifest before any download starts
+	if job == nil && !dry_run {
+		items := make([]queue.Item, 0, len(finalTargets))
+		for _, t := range finalTargets {
+			items = append(items, queue.Item{URL: t.URL, Codec: t.Codec, ArtistName: t.ArtistName, ArtistID: t.ArtistID})
+		}
+		job, err = queue.Create(queue.DefaultPath(cfg), items, queue.Options{
+			Atmos:       dl_atmos,
+			AAC:         dl_aac,
+			AacType:     cfg.AacType,
+			AlacMax:     cfg.AlacMax,
+			AtmosMax:    cfg.AtmosMax,
+			MVAudioType: cfg.MVAudioType,
+			MVMax:       cfg.MVMax,
+		}, token, cfg.Language)
+		if err != nil {
+			fmt.Fprintln(report.Out, "Failed to write job manifest:", err)
+		}
+	}
+
+	// a dry run reads which tracks are left but records nothing
+	if dry_run {
+		job.ReadOnly()
+	}
+
+	// Reset counter
+	counter = structs.Counter{}
+
+	// Execution Loop
+	execTotal := len(finalTargets)
+	for {
+		for i, t := range finalTargets {
+			urlRaw := t.URL
+			dl_atmos, dl_aac := t.modes()
+			dl_select, tracks_select := t.selection()
+			fmt.Fprintf(report.Out, "Queue %d of %d: ", i+1, execTotal)
+
+			if strings.Contains(urlRaw, "/music-video/") {
+				fmt.Fprintln(report.Out, "Music Video")
+				storefront, mvId := utils.CheckUrlMv(urlRaw)
+				counter.Add(&counter.Total, 1)
+				report.Emit(report.Event{Event: report.Start, ID: mvId})
+				if len(cfg.MediaUserToken) <= 50 {
+					fmt.Fprintln(report.Out, ": media-user-token is not set, skip MV dl")
+					counter.Add(&counter.Success, 1)
+					job.Mark(mvId, queue.Unavailable)
+					report.Emit(report.Event{Event: report.Unavailable, ID: mvId, Error: "media-user-token is not set"})
+					continue
+				}
+				if _, err := exec.LookPath("mp4decrypt"); err != nil {
+					fmt.Fprintln(report.Out, ": mp4decrypt is not found, skip MV dl")
+					counter.Add(&counter.Success, 1)
+					job.Mark(mvId, queue.Unavailable)
+					report.Emit(report.Event{Event: report.Unavailable, ID: mvId, Error: "mp4decrypt is not found"})
+					continue
+				}
+
+				mvSaveDir := cfg.AlacSaveFolder
+				if cfg.ArtistFolderFormat != "" {
+					fields := naming.Fields{Kind: "music-video", ArtistName: t.ArtistName, UrlArtistName: t.ArtistName, ArtistId: t.ArtistID}
+					name, err := naming.Render(cfg.ArtistFolderFormat, fields.Limit(cfg.LimitMax))
+					if err != nil {
+						return fmt.Errorf("invalid artist-folder-format: %w", err)
+					}
+					mvSaveDir = naming.Dir(cfg.AlacSaveFolder, name)
+				}
+
+				// Call MvDownloader
+				mvPath, err := downloader.MvDownloader(mvId, mvSaveDir, token, storefront, cfg.MediaUserToken, nil, cfg, &counter)
+				if err != nil {
+					fmt.Fprintln(report.Out, "\u26A0 Failed to dl MV:", err)
+					counter.Add(&counter.Error, 1)
+					job.Mark(mvId, queue.Failed)
+					report.Emit(report.Event{Event: report.Failed, ID: mvId, Error: err.Error()})
+					continue
+				}
+				counter.Add(&counter.Success, 1)
+				job.Mark(mvId, queue.Done)
+				report.Emit(report.Event{Event: report.Downloaded, ID: mvId, Codec: downloader.CodecName(dl_atmos, dl_aac), Path: mvPath})
+				continue
+			}
+
+			if strings.Contains(urlRaw, "/song/") {
+				fmt.Fprintf(report.Out, "Song->")
+				storefront, songId := utils.CheckUrlSong(urlRaw)
+				if storefront == "" || songId == "" {
+					fmt.Fprintln(report.Out, "Invalid song URL format.")
+					counter.Add(&counter.Error, 1)
+					job.MarkItem(urlRaw, errors.New("invalid song URL"))
+					continue
+				}
+				err := downloader.RipSong(songId, token, storefront, cfg.MediaUserToken, cfg, &counter, hist, job, dl_atmos, dl_aac)
+				if err != nil {
+					fmt.Fprintln(report.Out, "Failed to rip song:", err)
+					counter.Add(&counter.Error, 1)
+				}
+				job.MarkItem(urlRaw, err)
+				continue
+			}
+
+			parse, err := url.Parse(urlRaw)
+			if err != nil {
+				log.Fatalf("Invalid URL: %v", err)
+			}
+			var urlArg_i = parse.Query().Get("i")
+
+			if strings.Contains(urlRaw, "/album/") {
+				fmt.Fprintln(report.Out, "Album")
+				storefront, albumId := utils.CheckUrl(urlRaw)
+				albumCfg := cfg
+				if t.ArtistName != "" {
+					// name the artist folder after the artist URL, not the album artist
+					c := *cfg
+					c.ArtistFolderFormat = naming.Bind(c.ArtistFolderFormat, "UrlArtistName", utils.LimitString(t.ArtistName, cfg.LimitMax))
+					c.ArtistFolderFormat = naming.Bind(c.ArtistFolderFormat, "ArtistId", t.ArtistID)
+					albumCfg = &c
+				}
+				err := downloader.RipAlbum(albumId, token, storefront, cfg.MediaUserToken, urlArg_i, albumCfg, &counter, hist, job, dl_atmos, dl_aac, dl_select, tracks_select)
+				if err != nil {
+					fmt.Fprintln(report.Out, "Failed to rip album:", err)
+					counter.Add(&counter.Error, 1)
+				}
+				job.MarkItem(urlRaw, err)
+			} else if strings.Contains(urlRaw, "/playlist/") {
+				fmt.Fprintln(report.Out, "Playlist")
+				storefront, playlistId := utils.CheckUrlPlaylist(urlRaw)
+				err := downloader.RipPlaylist(playlistId, token, storefront, cfg.MediaUserToken, cfg, &counter, hist, job, dl_atmos, dl_aac, dl_select, tracks_select)
+				if err != nil {
+					fmt.Fprintln(report.Out, "Failed to rip playlist:", err)
+					counter.Add(&counter.Error, 1)
+				}
+				job.MarkItem(urlRaw, err)
+			} else if strings.Contains(urlRaw, "/station/") {
+				fmt.Fprintf(report.Out, "Station")
+				storefront, stationId := utils.CheckUrlStation(urlRaw)
+				if len(cfg.MediaUserToken) <= 50 {
+					fmt.Fprintln(report.Out, ": media-user-token is not set, skip station dl")
+					counter.Add(&counter.Error, 1)
+					job.MarkItem(urlRaw, errors.New("media-user-token is not set"))
+					continue
+				}
+				err := downloader.RipStation(stationId, token, storefront, cfg.MediaUserToken, cfg, &counter, hist, job, dl_atmos, dl_aac, dl_select)
+				if err != nil {
+					fmt.Fprintln(report.Out, "Failed to rip station:", err)
+					counter.Add(&counter.Error, 1)
+				}
+				job.MarkItem(urlRaw, err)
+			} else {
+				fmt.Fprintln(report.Out, "Invalid type")
+				counter.Add(&counter.Error, 1)
+				job.MarkItem(urlRaw, errors.New("invalid type"))
+			}
+		}
+
+		if dry_run {
+			fmt.Fprintln(report.Out, "Dry run, nothing was downloaded.")
+			break
+		}
+		fmt.Fprintf(report.Out, "=======  [\u2714 ] Completed: %d/%d  |  [\u26A0 ] Warnings: %d  |  [\u2716 ] Errors: %d  =======\n", counter.Success, counter.Total, counter.Unavailable+counter.NotSong, counter.Error)
+		if err := report.Finish(&counter); err != nil {
+			fmt.Fprintln(report.Out, "Failed to write run report:", err)
+		}
+		if counter.Error == 0 {
+			break
+		}
+		if job != nil {
+			fmt.Fprintf(report.Out, "Progress saved to %s, run `amdl resume` to continue later.\n", job.Path())
+		}
+		if non_interactive {
+			return fmt.Errorf("%d downloads failed", counter.Error)
+		}
+		fmt.Fprintln(report.Out, "Error detected, press Enter to try again...")
+		fmt.Scanln()
+		fmt.Fprintln(report.Out, "Start trying again...")
+		counter = structs.Counter{}
+	}
+	if expandErrors > 0 {
+		return fmt.Errorf("%d artist lookups failed", expandErrors)
+	}
+	return nil
+}
+
+// checkNonInteractive makes sure every prompt a run would reach has its
+// selection on the command line or the input line.
+func checkNonInteractive(targets []target) error {
+	for _, t := range targets {
+		// stations are downloaded whole, see RipStation
+		if sel, tracks := t.selection(); sel && tracks == "" && !strings.Contains(t.URL, "/station/") {
+			return fmt.Errorf("--select needs --tracks in non-interactive mode: %s", t.URL)
+		}
+		if !strings.Contains(t.URL, "/artist/") {
+			continue
+		}
+		if (albums_select == "" && t.Albums == "") || (mvs_select == "" && t.MVs == "") {
+			return fmt.Errorf("artists need --albums and --mvs in non-interactive mode: %s", t.URL)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"main/internal/history"
+	"main/internal/structs"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/pflag"
+)
+
+// runHistory implements `amdl history list|forget`.
+func runHistory(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("history", pflag.ContinueOnError)
+	all := fs.Bool("all", false, "Forget every entry")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s history list [filter]\n", "amdl")
+		fmt.Fprintf(os.Stderr, "       %s history forget [--all] <adam-id|album-id|isrc|path-prefix>...\n", "amdl")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing history action")
+	}
+
+	store, err := history.Open(history.DefaultPath(cfg))
+	if err != nil {
+		return err
+	}
+
+	action, terms := fs.Arg(0), fs.Args()[1:]
+	switch action {
+	case "list":
+		var data [][]string
+		for _, e := range store.List() {
+			if len(terms) > 0 && !matchEntry(e, terms) {
+				continue
+			}
+			data = append(data, []string{e.Time.Format("2006-01-02 15:04"), e.AdamID, e.AlbumID, e.ISRC, e.Codec, e.Quality, e.Path})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Date", "Adam ID", "Album ID", "ISRC", "Codec", "Quality", "Path"})
+		table.SetRowLine(false)
+		table.SetCaption(true, fmt.Sprintf("%d entries in %s", len(data), store.Path()))
+		table.AppendBulk(data)
+		table.Render()
+	case "forget":
+		if len(terms) == 0 && !*all {
+			return fmt.Errorf("forget needs an ID, ISRC or path prefix, or --all")
+		}
+		removed, err := store.Forget(func(e history.Entry) bool {
+			return *all || matchEntry(e, terms)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Forgot %d entries.\n", removed)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown history action: %s", action)
+	}
+	return nil
+}
+
+func matchEntry(e history.Entry, terms []string) bool {
+	for _, t := range terms {
+		if t == e.AdamID || t == e.AlbumID || strings.EqualFold(t, e.ISRC) || strings.HasPrefix(e.Path, t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"main/internal/api"
+	"main/internal/downloader"
+	"main/internal/structs"
+	"main/internal/task"
+	"main/internal/utils"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/pflag"
+)
+
+// runInfo implements `amdl info`, the former --debug mode.
+func runInfo(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("info", pflag.ContinueOnError)
+	variants := fs.Bool("variants", false, "Probe every track for its available codecs and qualities")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s info [--variants] <album|playlist|song url>...\n", "amdl")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no URLs provided")
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, rawUrl := range fs.Args() {
+		var tracks []task.Track
+		switch {
+		case strings.Contains(rawUrl, "/album/"):
+			storefront, albumId := utils.CheckUrl(rawUrl)
+			album := task.NewAlbum(storefront, albumId)
+			if err := album.GetResp(token, cfg.Language); err != nil {
+				return err
+			}
+			attrs := album.Resp.Data[0].Attributes
+			fmt.Printf("%s - %s\n", attrs.ArtistName, attrs.Name)
+			fmt.Printf("Released %s, %s, UPC %s\n", attrs.ReleaseDate, attrs.RecordLabel, attrs.Upc)
+			fmt.Printf("Audio: %s\n", strings.Join(attrs.AudioTraits, ", "))
+			tracks = album.Tracks
+		case strings.Contains(rawUrl, "/playlist/"):
+			storefront, playlistId := utils.CheckUrlPlaylist(rawUrl)
+			playlist := task.NewPlaylist(storefront, playlistId)
+			if err := playlist.GetResp(token, cfg.Language); err != nil {
+				return err
+			}
+			fmt.Println(playlist.Name)
+			tracks = playlist.Tracks
+		case strings.Contains(rawUrl, "/song/"):
+			storefront, songId := utils.CheckUrlSong(rawUrl)
+			song, err := api.GetSongResp(storefront, songId, cfg.Language, token)
+			if err != nil {
+				return err
+			}
+			if len(song.Data) == 0 || len(song.Data[0].Relationships.Albums.Data) == 0 {
+				fmt.Println("No album found for song:", rawUrl)
+				continue
+			}
+			albumId := song.Data[0].Relationships.Albums.Data[0].ID
+			album := task.NewAlbum(storefront, albumId)
+			if err := album.GetResp(token, cfg.Language); err != nil {
+				return err
+			}
+			for _, t := range album.Tracks {
+				if t.ID == songId {
+					tracks = append(tracks, t)
+				}
+			}
+		default:
+			fmt.Println("Unsupported URL:", rawUrl)
+			continue
+		}
+
+		var data [][]string
+		for _, t := range tracks {
+			data = append(data, []string{
+				fmt.Sprint(t.TaskNum),
+				t.Name,
+				t.Resp.Attributes.ContentRating,
+				strings.Join(t.Resp.Attributes.AudioTraits, ", "),
+				t.ID,
+			})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"", "Track Name", "Rating", "Audio Traits", "ID"})
+		table.SetRowLine(false)
+		table.AppendBulk(data)
+		table.Render()
+
+		if !*variants {
+			continue
+		}
+		for _, t := range tracks {
+			if t.Type == "music-videos" || t.M3u8 == "" {
+				continue
+			}
+			fmt.Printf("\n%d. %s\n", t.TaskNum, t.Name)
+			if _, _, err := downloader.ExtractMedia(t.M3u8, true, cfg, false, false, true); err != nil {
+				fmt.Println("Failed to extract quality from manifest:", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// target is one URL to download together with the overrides of its input
+// line. Empty fields fall back to the command line flags.
+type target struct {
+	URL    string
+	Codec  string // alac, atmos or aac
+	Tracks string
+	Albums string
+	MVs    string
+
+	// set for the albums and videos of an artist URL
+	ArtistName string
+	ArtistID   string
+}
+
+// modes returns the atmos and aac switches for t.
+func (t target) modes() (bool, bool) {
+	switch t.Codec {
+	case "atmos":
+		return true, false
+	case "aac":
+		return false, true
+	case "alac":
+		return false, false
+	}
+	return dl_atmos, dl_aac
+}
+
+// selection returns the select switch and track selection for t.
+func (t target) selection() (bool, string) {
+	if t.Tracks != "" {
+		return true, t.Tracks
+	}
+	return dl_select, tracks_select
+}
+
+// collectTargets gathers the URLs of the positional args and the input file.
+// An arg of "-" reads the list from stdin.
+func collectTargets(args []string, inputFile string) ([]target, error) {
+	var targets []target
+	if inputFile != "" {
+		f, err := os.Open(inputFile)
+		if err != nil {
+			return nil, err
+		}
+		list, err := readTargets(f, inputFile)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, list...)
+	}
+	for _, arg := range args {
+		if arg != "-" {
+			targets = append(targets, target{URL: arg})
+			continue
+		}
+		list, err := readTargets(os.Stdin, "stdin")
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, list...)
+	}
+	return targets, nil
+}
+
+// readTargets parses a URL list: one URL per line followed by optional
+// key=value overrides (codec, tracks, albums, mvs). Blank lines and lines
+// starting with # are skipped.
+func readTargets(r io.Reader, name string) ([]target, error) {
+	var targets []target
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		t := target{URL: fields[0]}
+		for _, field := range fields[1:] {
+			if strings.HasPrefix(field, "#") {
+				break
+			}
+			key, value, ok := strings.Cut(field, "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("%s:%d: expected key=value, got %q", name, lineNum, field)
+			}
+			switch key {
+			case "codec":
+				value = strings.ToLower(value)
+				if value != "alac" && value != "atmos" && value != "aac" {
+					return nil, fmt.Errorf("%s:%d: unknown codec %q (alac, atmos, aac)", name, lineNum, value)
+				}
+				t.Codec = value
+			case "tracks":
+				t.Tracks = value
+			case "albums":
+				t.Albums = value
+			case "mvs":
+				t.MVs = value
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown option %q", name, lineNum, key)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, scanner.Err()
+}
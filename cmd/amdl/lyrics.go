@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"main/internal/lyrics"
+	"main/internal/structs"
+	"main/internal/tagger"
+	"main/internal/utils"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/pflag"
+)
+
+// runLyrics implements `amdl lyrics`.
+func runLyrics(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("lyrics", pflag.ContinueOnError)
+	lrcType := fs.String("type", cfg.LrcType, "Lyrics type: lyrics or syllable-lyrics")
+	lrcFormat := fs.String("format", cfg.LrcFormat, "Lyrics format: "+strings.Join(lyrics.Formats, ", "))
+	out := fs.StringP("out", "o", "", "Write the lyrics to this file instead of stdout")
+	embed := fs.Bool("embed", cfg.EmbedLrc, "Backfill: embed the lyrics into MP4 files")
+	save := fs.Bool("save", cfg.SaveLrcFile, "Backfill: write the lyrics next to the files")
+	force := fs.Bool("force", false, "Backfill: fetch lyrics for files that already have them")
+	refresh := fs.Bool("refresh-lyrics", false, "Fetch lyrics again instead of using the lyrics cache")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s lyrics [options] <song url|album url?i=id>\n", "amdl")
+		fmt.Fprintf(os.Stderr, "       %s lyrics [--embed] [--save] [--force] <path|album url|song url>...\n", "amdl")
+		fmt.Fprintln(os.Stderr, "The second form adds lyrics to files downloaded before.")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	lyrics.Refresh = *refresh
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("lyrics needs a song URL or a path")
+	}
+	if _, id := songOf(fs.Arg(0)); fs.NArg() > 1 || id == "" || fs.Changed("embed") || fs.Changed("save") {
+		if *out != "" {
+			return fmt.Errorf("--out only works with one song URL")
+		}
+		return backfillLyrics(cfg, fs.Args(), *lrcType, *lrcFormat, *embed, *save, *force)
+	}
+	storefront, songId := songOf(fs.Arg(0))
+	if songId == "" {
+		return fmt.Errorf("not a song URL: %s", fs.Arg(0))
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+	lrc, err := lyrics.Get(storefront, songId, *lrcType, cfg.Language, *lrcFormat, token, cfg.MediaUserToken)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		fmt.Println(lrc)
+		return nil
+	}
+	return utils.WriteFile(*out, []byte(lrc))
+}
+
+// backfillLyrics fetches the lyrics of downloaded files that have none yet,
+// embeds them into MP4 files and writes them next to the files as asked,
+// and lists the tracks without lyrics in the catalog.
+func backfillLyrics(cfg *structs.ConfigSet, args []string, lrcType string, lrcFormat string, embed bool, save bool, force bool) error {
+	if !embed && !save {
+		return fmt.Errorf("nothing to do: use --embed or --save")
+	}
+	if len(cfg.MediaUserToken) <= 50 {
+		return fmt.Errorf("media-user-token is not set")
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+	m, err := newMatcher(cfg, token)
+	if err != nil {
+		return err
+	}
+	files, failed := m.filesOf(args)
+	added, present := 0, 0
+	var missing [][]string
+	for _, f := range files {
+		isMP4 := strings.EqualFold(filepath.Ext(f.Path), ".m4a")
+		base := strings.TrimSuffix(f.Path, filepath.Ext(f.Path))
+		sidecar := lyrics.FileName(base, lrcFormat, "")
+		needEmbed := embed && isMP4 && (force || !f.HasLyrics)
+		needSave := save
+		if save && !force {
+			exists, _ := utils.FileExists(sidecar)
+			needSave = !exists
+		}
+		if !needEmbed && !needSave {
+			// embedding is skipped for formats other than MP4
+			if save || isMP4 {
+				present++
+			}
+			continue
+		}
+
+		track, err := m.track(f)
+		if err != nil {
+			fmt.Printf("⚠ %s: %v\n", f.Path, err)
+			failed++
+			continue
+		}
+		if !track.Resp.Attributes.HasLyrics {
+			missing = append(missing, []string{f.Path, "no lyrics in the catalog"})
+			continue
+		}
+		ttml, err := lyrics.Get(track.Storefront, track.ID, lrcType, cfg.Language, "ttml", token, cfg.MediaUserToken)
+		if err != nil {
+			missing = append(missing, []string{f.Path, err.Error()})
+			continue
+		}
+		fmt.Println(f.Path)
+		if needSave {
+			files, err := lyrics.Files(ttml, lrcFormat)
+			for _, lf := range files {
+				if err == nil {
+					err = utils.WriteFile(lyrics.FileName(base, lrcFormat, lf.Language), []byte(lf.Text))
+				}
+			}
+			if err != nil {
+				fmt.Printf("⚠ %s: %v\n", sidecar, err)
+				failed++
+				continue
+			}
+		}
+		if needEmbed {
+			text, err := lyrics.Convert(ttml, lyrics.EmbedFormat(lrcFormat))
+			// the library file is only replaced once the lyrics are in
+			if err == nil {
+				err = utils.CopyPart(f.Path)
+			}
+			if err == nil {
+				if err = tagger.EmbedLyrics(utils.PartPath(f.Path), text); err == nil {
+					err = utils.CommitPart(f.Path)
+				} else {
+					os.Remove(utils.PartPath(f.Path))
+				}
+			}
+			if err != nil {
+				fmt.Printf("⚠ %s: %v\n", f.Path, err)
+				failed++
+				continue
+			}
+		}
+		added++
+	}
+
+	if len(missing) > 0 {
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"File", "No lyrics"})
+		table.SetRowLine(false)
+		table.AppendBulk(missing)
+		table.Render()
+	}
+	fmt.Printf("Lyrics added: %d, Already present: %d, Not available: %d, Failed: %d\n", added, present, len(missing), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not get lyrics", failed)
+	}
+	return nil
+}
+
+// songOf returns the storefront and song ID of a song URL or an album URL
+// pointing at one track with ?i=.
+func songOf(rawUrl string) (string, string) {
+	if strings.Contains(rawUrl, "/song/") {
+		return utils.CheckUrlSong(rawUrl)
+	}
+	if strings.Contains(rawUrl, "/album/") {
+		parse, err := url.Parse(rawUrl)
+		if err != nil {
+			return "", ""
+		}
+		storefront, _ := utils.CheckUrl(rawUrl)
+		return storefront, parse.Query().Get("i")
+	}
+	return "", ""
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"main/internal/api"
+	"main/internal/audit"
+	"main/internal/downloader"
+	"main/internal/history"
+	"main/internal/lyrics"
+	"main/internal/metadata"
+	"main/internal/structs"
+	"main/internal/task"
+	"main/internal/utils"
+
+	"github.com/spf13/pflag"
+)
+
+// runRetag implements `amdl retag`, which rewrites the tags of downloaded
+// files from the catalog.
+func runRetag(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("retag", pflag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Print the catalog track each file matches without writing")
+	refresh := fs.Bool("refresh-lyrics", false, "Fetch lyrics again instead of using the lyrics cache")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s retag [options] <path|album-url|song-url>...\n", "amdl")
+		fmt.Fprintln(os.Stderr, "Paths are files or folders; URLs are looked up in the history and the save folders.")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	lyrics.Refresh = *refresh
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("missing path or url")
+	}
+	token, err := getToken(cfg)
+	if err != nil {
+		return err
+	}
+	m, err := newMatcher(cfg, token)
+	if err != nil {
+		return err
+	}
+	files, failed := m.filesOf(fs.Args())
+	done := 0
+	for _, f := range files {
+		if err := retagFile(m, f, *dryRun); err != nil {
+			fmt.Printf("⚠ %s: %v\n", f.Path, err)
+			failed++
+			continue
+		}
+		done++
+	}
+	fmt.Printf("Retagged: %d, Failed: %d\n", done, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files could not be retagged", failed)
+	}
+	return nil
+}
+
+// retagFile rewrites the tags of f from its catalog track.
+func retagFile(m *matcher, f *audit.File, dryRun bool) error {
+	track, err := m.track(f)
+	if err != nil {
+		return err
+	}
+	// MP4 files of playlists carry no album ID; they keep their place in the
+	// playlist unless they should get the data of their album
+	playlist := f.Tagged() && f.AlbumID == ""
+	if playlist {
+		copied := *track
+		copied.PreType, copied.PreID = "playlists", ""
+		track = &copied
+	}
+	meta := metadata.FromTrack(track, m.cfg.UseSongInfoForPlaylist, m.cfg.DiscFolderFormat != "")
+	if playlist && !m.cfg.UseSongInfoForPlaylist {
+		meta.Album = f.Album
+		meta.AlbumArtist = f.AlbumArtist
+		meta.TrackNumber, meta.TrackTotal = f.Track, f.TrackTotal
+	}
+	if dryRun {
+		fmt.Printf("%s: %s - %s (%s)\n", f.Path, meta.Artist, meta.Title, track.ID)
+		return nil
+	}
+	fmt.Println(f.Path)
+	return downloader.Retag(f.Path, track, meta, m.token, m.cfg.MediaUserToken, m.cfg)
+}
+
+// matcher finds downloaded files and the catalog tracks they are a download
+// of, fetching each album once.
+type matcher struct {
+	cfg     *structs.ConfigSet
+	token   string
+	entries map[string]history.Entry // history by file path
+	albums  map[string]*task.Album
+	library []*audit.File // the save folders, scanned on first use
+}
+
+func newMatcher(cfg *structs.ConfigSet, token string) (*matcher, error) {
+	hist, err := history.Open(history.DefaultPath(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("load history failed: %w", err)
+	}
+	entries := make(map[string]history.Entry)
+	for _, e := range hist.List() {
+		entries[filepath.Clean(e.Path)] = e
+	}
+	return &matcher{cfg: cfg, token: token, entries: entries, albums: make(map[string]*task.Album)}, nil
+}
+
+// filesOf returns the files of every argument once, and how many arguments
+// could not be resolved.
+func (m *matcher) filesOf(args []string) ([]*audit.File, int) {
+	var files []*audit.File
+	failed := 0
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		found, err := m.files(arg)
+		if err != nil {
+			fmt.Printf("%s: %v\n", arg, err)
+			failed++
+			continue
+		}
+		if len(found) == 0 {
+			fmt.Println("No downloaded files found for", arg)
+		}
+		for _, f := range found {
+			if !seen[f.Path] {
+				seen[f.Path] = true
+				files = append(files, f)
+			}
+		}
+	}
+	return files, failed
+}
+
+// files returns the audio files arg stands for: the files below a path, or
+// the downloads of an album or song URL.
+func (m *matcher) files(arg string) ([]*audit.File, error) {
+	if storefront, id := songOf(arg); id != "" {
+		song, err := api.GetSongResp(storefront, id, m.cfg.Language, m.token)
+		if err != nil {
+			return nil, err
+		}
+		var albumID string
+		if len(song.Data[0].Relationships.Albums.Data) > 0 {
+			albumID = song.Data[0].Relationships.Albums.Data[0].ID
+		}
+		isrc := song.Data[0].Attributes.Isrc
+		var found []*audit.File
+		for _, f := range m.scanLibrary() {
+			if m.entries[filepath.Clean(f.Path)].AdamID == id || (f.AlbumID == albumID && f.ISRC == isrc) {
+				found = append(found, f)
+			}
+		}
+		return found, nil
+	}
+	if _, id := utils.CheckUrl(arg); id != "" {
+		var found []*audit.File
+		for _, f := range m.scanLibrary() {
+			if f.AlbumID == id || m.entries[filepath.Clean(f.Path)].AlbumID == id {
+				found = append(found, f)
+			}
+		}
+		return found, nil
+	}
+	if _, err := os.Stat(arg); err != nil {
+		return nil, err
+	}
+	return audit.Scan(map[string]string{arg: ""}), nil
+}
+
+func (m *matcher) scanLibrary() []*audit.File {
+	if m.library == nil {
+		m.library = audit.Scan(map[string]string{
+			m.cfg.AlacSaveFolder:  "alac",
+			m.cfg.AtmosSaveFolder: "atmos",
+			m.cfg.AacSaveFolder:   "aac",
+		})
+	}
+	return m.library
+}
+
+// track returns the catalog track f is a download of. A file is identified
+// by its history entry or else by the album ID and ISRC in its tags. Files
+// with an ISRC but no album ID are looked up by the ISRC.
+func (m *matcher) track(f *audit.File) (*task.Track, error) {
+	entry := m.entries[filepath.Clean(f.Path)]
+	albumID := f.AlbumID
+	if albumID == "" {
+		albumID = entry.AlbumID
+	}
+	isrc := f.ISRC
+	if isrc == "" {
+		isrc = entry.ISRC
+	}
+	if albumID == "" && isrc != "" {
+		id, err := m.albumOf(isrc, f)
+		if err != nil {
+			return nil, err
+		}
+		albumID = id
+	}
+	if albumID == "" {
+		return nil, fmt.Errorf("no album ID or ISRC in its tags or the history")
+	}
+	album, err := m.album(albumID)
+	if err != nil {
+		return nil, err
+	}
+	track := matchTrack(album, entry.AdamID, isrc, f.Disc, f.Track)
+	if track == nil {
+		return nil, fmt.Errorf("no track of album %s matches", albumID)
+	}
+	return track, nil
+}
+
+// albumOf returns the ID of the album of the song recorded under isrc. Of
+// several songs with the ISRC the one on the album named in the tags of f is
+// taken, else the first one.
+func (m *matcher) albumOf(isrc string, f *audit.File) (string, error) {
+	songs, err := api.GetSongsByIsrc(m.cfg.Storefront, isrc, m.cfg.Language, m.token)
+	if err != nil {
+		return "", err
+	}
+	id := ""
+	for _, song := range songs.Data {
+		if len(song.Relationships.Albums.Data) == 0 {
+			continue
+		}
+		if song.Attributes.AlbumName == f.Album {
+			return song.Relationships.Albums.Data[0].ID, nil
+		}
+		if id == "" {
+			id = song.Relationships.Albums.Data[0].ID
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no album of a song with ISRC %s", isrc)
+	}
+	return id, nil
+}
+
+// album returns album id from the catalog.
+func (m *matcher) album(id string) (*task.Album, error) {
+	if album, ok := m.albums[id]; ok {
+		return album, nil
+	}
+	album := task.NewAlbum(m.cfg.Storefront, id)
+	if err := album.GetResp(m.token, m.cfg.Language); err != nil {
+		return nil, err
+	}
+	m.albums[id] = album
+	return album, nil
+}
+
+// matchTrack returns the track of album with the given ID, else the one with
+// the ISRC, else the one at disc and track number.
+func matchTrack(album *task.Album, adamID string, isrc string, disc int, number int) *task.Track {
+	for _, match := range []func(t *task.Track) bool{
+		func(t *task.Track) bool { return adamID != "" && t.ID == adamID },
+		func(t *task.Track) bool { return isrc != "" && t.Resp.Attributes.Isrc == isrc },
+		func(t *task.Track) bool {
+			return number > 0 && t.Resp.Attributes.DiscNumber == disc && t.Resp.Attributes.TrackNumber == number
+		},
+	} {
+		for i := range album.Tracks {
+			if album.Tracks[i].Type != "music-videos" && match(&album.Tracks[i]) {
+				return &album.Tracks[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"main/internal/audit"
+	"main/internal/downloader"
+	"main/internal/history"
+	"main/internal/queue"
+	"main/internal/structs"
+	"main/internal/utils"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/pflag"
+)
+
+// runVerify implements `amdl verify`, an audit of the downloaded library.
+func runVerify(cfg *structs.ConfigSet, args []string) error {
+	fs := pflag.NewFlagSet("verify", pflag.ContinueOnError)
+	offline := fs.Bool("offline", false, "Only check the files, without comparing albums with the catalog")
+	reportFile := fs.String("report", filepath.Join(filepath.Dir(filepath.Clean(cfg.AlacSaveFolder)), "verify.json"), "Write the findings to this JSON file; \"\" disables it")
+	repair := fs.Bool("repair", false, "Set broken files aside and write a job manifest that `amdl resume` downloads again")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s verify [options] [folder...]\n", "amdl")
+		fmt.Fprintln(os.Stderr, "Checks the ALAC, Atmos and AAC save folders, or the given folders.")
+		fmt.Fprintln(os.Stderr, "\nOptions:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	roots := map[string]string{
+		cfg.AlacSaveFolder:  "alac",
+		cfg.AtmosSaveFolder: "atmos",
+		cfg.AacSaveFolder:   "aac",
+	}
+	if fs.NArg() > 0 {
+		given := make(map[string]string)
+		for _, dir := range fs.Args() {
+			given[dir] = codecOfFolder(dir, roots)
+		}
+		roots = given
+	}
+	delete(roots, "")
+
+	tolerance := time.Duration(cfg.VerifyTolerance) * time.Second
+	if tolerance <= 0 {
+		tolerance = 2 * time.Second
+	}
+	opts := audit.Options{
+		Cover:      cfg.EmbedCover,
+		Lyrics:     cfg.EmbedLrc && len(cfg.MediaUserToken) > 50,
+		Catalog:    !*offline,
+		Tolerance:  tolerance,
+		FFprobe:    downloader.FFprobe(cfg),
+		Discs:      cfg.DiscFolderFormat != "",
+		Storefront: cfg.Storefront,
+		Language:   cfg.Language,
+	}
+	if opts.Catalog || *repair {
+		token, err := getToken(cfg)
+		if err != nil {
+			return err
+		}
+		opts.Token = token
+	}
+
+	files := audit.Scan(roots)
+	fmt.Printf("Checking %d files...\n", len(files))
+	report := audit.Run(files, opts, func(path string) {
+		fmt.Println(path)
+	})
+
+	if len(report.Problems) > 0 {
+		var data [][]string
+		for _, p := range report.Problems {
+			where := p.Path
+			if where == "" {
+				where = "album " + p.AlbumID
+			}
+			data = append(data, []string{p.Kind, where, p.Detail})
+		}
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Problem", "File", "Detail"})
+		table.SetRowLine(false)
+		table.AppendBulk(data)
+		table.Render()
+	}
+	fmt.Printf("%d files in %d albums checked, %d problems.\n", report.Files, report.Albums, len(report.Problems))
+
+	if *reportFile != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := utils.WriteFile(*reportFile, data); err != nil {
+			return fmt.Errorf("write verify report failed: %w", err)
+		}
+		fmt.Println("Report written to", *reportFile)
+	}
+
+	if *repair && len(report.Problems) > 0 {
+		if err := enqueueRepairs(cfg, report, opts.Token); err != nil {
+			return err
+		}
+	}
+	if len(report.Problems) > 0 {
+		return fmt.Errorf("%d problems found", len(report.Problems))
+	}
+	return nil
+}
+
+// enqueueRepairs sets the broken files of report aside, forgets them in the
+// history and writes a job manifest with their albums and those missing
+// tracks, for `amdl resume` to download. Files that only lack tags, covers
+// or lyrics are listed for retag and lyrics instead.
+func enqueueRepairs(cfg *structs.ConfigSet, report *audit.Report, token string) error {
+	hist, err := history.Open(history.DefaultPath(cfg))
+	if err != nil {
+		return fmt.Errorf("load history failed: %w", err)
+	}
+	broken := make(map[string]bool)
+	for _, p := range report.Broken() {
+		if broken[p.Path] {
+			continue
+		}
+		if err := audit.SetAside(p.Path); err != nil {
+			fmt.Println("Failed to set aside:", err)
+			continue
+		}
+		broken[p.Path] = true
+	}
+	if _, err := hist.Forget(func(e history.Entry) bool {
+		return broken[filepath.Clean(e.Path)]
+	}); err != nil {
+		return err
+	}
+
+	// one item per album and codec; files of playlists have no album and
+	// are only set aside
+	var items []queue.Item
+	queued := make(map[string]bool)
+	for _, p := range report.Problems {
+		if p.AlbumID == "" || p.Kind == audit.Catalog || (p.Path != "" && !broken[p.Path]) {
+			continue
+		}
+		key := p.AlbumID + "/" + p.Codec
+		if queued[key] {
+			continue
+		}
+		queued[key] = true
+		items = append(items, queue.Item{URL: audit.AlbumURL(cfg.Storefront, p.AlbumID), Codec: p.Codec})
+	}
+	fmt.Printf("Set %d broken files aside.\n", len(broken))
+	fixes := make(map[string][]string)
+	for _, p := range report.Problems {
+		if fix := p.Fix(); fix != "" && !broken[p.Path] && !slices.Contains(fixes[fix], p.Path) {
+			fixes[fix] = append(fixes[fix], p.Path)
+		}
+	}
+	for _, fix := range []string{"retag", "lyrics"} {
+		if len(fixes[fix]) == 0 {
+			continue
+		}
+		fmt.Printf("%d files keep their audio; run `amdl %s` on them:\n", len(fixes[fix]), fix)
+		for _, path := range fixes[fix] {
+			fmt.Println("  " + path)
+		}
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	job, err := queue.Create(queue.DefaultPath(cfg), items, queue.Options{
+		AacType:     cfg.AacType,
+		AlacMax:     cfg.AlacMax,
+		AtmosMax:    cfg.AtmosMax,
+		MVAudioType: cfg.MVAudioType,
+		MVMax:       cfg.MVMax,
+	}, token, cfg.Language)
+	if err != nil {
+		return fmt.Errorf("write job manifest failed: %w", err)
+	}
+	fmt.Printf("Queued %d albums in %s; run `amdl resume` to download them.\n", len(items), job.Path())
+	return nil
+}
+
+// codecOfFolder returns the codec of the save folder dir is in, or alac.
+func codecOfFolder(dir string, roots map[string]string) string {
+	abs, _ := filepath.Abs(dir)
+	for root, codec := range roots {
+		if root == "" {
+			continue
+		}
+		rootAbs, _ := filepath.Abs(root)
+		if abs == rootAbs || strings.HasPrefix(abs, rootAbs+string(filepath.Separator)) {
+			return codec
+		}
+	}
+	return "alac"
+}
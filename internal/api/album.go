@@ -1,30 +1,12 @@
 package api
 
 import (
-	"encoding/json"
-	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
 	"strings"
 )
 
 func GetAlbumResp(storefront string, id string, language string, token string) (*AlbumResp, error) {
-	var err error
-	if token == "" {
-		token, err = GetToken()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/albums/%s", storefront, id), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
 	query := url.Values{}
 	query.Set("omit[resource]", "autos")
 	query.Set("include", "tracks,artists,record-labels")
@@ -34,74 +16,22 @@ func GetAlbumResp(storefront string, id string, language string, token string) (
 	//query.Set("fields[record-labels]", "name")
 	query.Set("extend", "editorialVideo,extendedAssetUrls")
 	query.Set("l", language)
-	req.URL.RawQuery = query.Encode()
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return nil, errors.New(do.Status)
-	}
 	obj := new(AlbumResp)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("GET", fmt.Sprintf("/v1/catalog/%s/albums/%s", storefront, id), query, token, nil, obj)
 	if err != nil {
 		return nil, err
 	}
-	if len(obj.Data[0].Relationships.Tracks.Next) > 0 {
-		next := obj.Data[0].Relationships.Tracks.Next
-		for {
-			req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com%s", next), nil)
-			if err != nil {
-				return nil, err
-			}
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-			req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-			req.Header.Set("Origin", "https://music.apple.com")
-			query := req.URL.Query()
-			query.Set("omit[resource]", "autos")
-			query.Set("include", "artists")
-			query.Set("extend", "editorialVideo,extendedAssetUrls")
-			req.URL.RawQuery = query.Encode()
-			do, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return nil, err
-			}
-			defer do.Body.Close()
-			if do.StatusCode != http.StatusOK {
-				return nil, errors.New(do.Status)
-			}
-			obj2 := new(TrackResp)
-			err = json.NewDecoder(do.Body).Decode(&obj2)
-			if err != nil {
-				return nil, err
-			}
-			obj.Data[0].Relationships.Tracks.Data = append(obj.Data[0].Relationships.Tracks.Data, obj2.Data...)
-			next = obj2.Next
-			if len(next) == 0 {
-				break
-			}
+	if len(obj.Data) > 0 {
+		err = fetchRemainingTracks(&obj.Data[0].Relationships.Tracks, token)
+		if err != nil {
+			return nil, err
 		}
 	}
 	return obj, nil
 }
 
 func GetAlbumRespByHref(href string, language string, token string) (*AlbumResp, error) {
-	var err error
-	if token == "" {
-		token, err = GetToken()
-		if err != nil {
-			return nil, err
-		}
-	}
 	href = strings.Split(href, "?")[0]
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com%s/albums", href), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
 	query := url.Values{}
 	query.Set("omit[resource]", "autos")
 	query.Set("include", "tracks,artists,record-labels")
@@ -111,58 +41,40 @@ func GetAlbumRespByHref(href string, language string, token string) (*AlbumResp,
 	//query.Set("fields[record-labels]", "name")
 	query.Set("extend", "editorialVideo,extendedAssetUrls")
 	query.Set("l", language)
-	req.URL.RawQuery = query.Encode()
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return nil, errors.New(do.Status)
-	}
 	obj := new(AlbumResp)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("GET", href+"/albums", query, token, nil, obj)
 	if err != nil {
 		return nil, err
 	}
-	if len(obj.Data[0].Relationships.Tracks.Next) > 0 {
-		next := obj.Data[0].Relationships.Tracks.Next
-		for {
-			req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com%s", next), nil)
-			if err != nil {
-				return nil, err
-			}
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-			req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-			req.Header.Set("Origin", "https://music.apple.com")
-			query := req.URL.Query()
-			query.Set("omit[resource]", "autos")
-			query.Set("include", "artists")
-			query.Set("extend", "editorialVideo,extendedAssetUrls")
-			req.URL.RawQuery = query.Encode()
-			do, err := http.DefaultClient.Do(req)
-			if err != nil {
-				return nil, err
-			}
-			defer do.Body.Close()
-			if do.StatusCode != http.StatusOK {
-				return nil, errors.New(do.Status)
-			}
-			obj2 := new(TrackResp)
-			err = json.NewDecoder(do.Body).Decode(&obj2)
-			if err != nil {
-				return nil, err
-			}
-			obj.Data[0].Relationships.Tracks.Data = append(obj.Data[0].Relationships.Tracks.Data, obj2.Data...)
-			next = obj2.Next
-			if len(next) == 0 {
-				break
-			}
+	if len(obj.Data) > 0 {
+		err = fetchRemainingTracks(&obj.Data[0].Relationships.Tracks, token)
+		if err != nil {
+			return nil, err
 		}
 	}
 	return obj, nil
 }
 
+// fetchRemainingTracks follows the tracks relationship's next links and
+// appends every page to tracks.
+func fetchRemainingTracks(tracks *TrackResp, token string) error {
+	next := tracks.Next
+	for len(next) > 0 {
+		query := url.Values{}
+		query.Set("omit[resource]", "autos")
+		query.Set("include", "artists")
+		query.Set("extend", "editorialVideo,extendedAssetUrls")
+		obj := new(TrackResp)
+		err := DefaultClient.FetchJSON("GET", next, query, token, nil, obj)
+		if err != nil {
+			return err
+		}
+		tracks.Data = append(tracks.Data, obj.Data...)
+		next = obj.Next
+	}
+	return nil
+}
+
 type AlbumResp struct {
 	Href string          `json:"href"`
 	Next string          `json:"next"`
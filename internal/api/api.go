@@ -1,14 +1,13 @@
 package api
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
 	"sort"
 	"time"
 
+	"main/internal/report"
 	"main/internal/structs"
 	"main/internal/utils"
 )
@@ -18,7 +17,7 @@ func GetUrlSong(songUrl string, token string, lang string) (string, error) {
 	storefront, songId := utils.CheckUrlSong(songUrl)
 	manifest, err := GetSongResp(storefront, songId, lang, token)
 	if err != nil {
-		fmt.Println("\u26A0 Failed to get manifest:", err)
+		fmt.Fprintln(report.Out, "\u26A0 Failed to get manifest:", err)
 		return "", err
 	}
 	// Assuming manifest structure matches what was in main.go
@@ -33,26 +32,10 @@ func GetUrlSong(songUrl string, token string, lang string) (string, error) {
 // GetUrlArtistName fetches the artist name and ID.
 func GetUrlArtistName(artistUrl string, token string, lang string) (string, string, error) {
 	storefront, artistId := utils.CheckUrlArtist(artistUrl)
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/artists/%s", storefront, artistId), nil)
-	if err != nil {
-		return "", "", err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
 	query := url.Values{}
 	query.Set("l", lang)
-	req.URL.RawQuery = query.Encode()
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", "", err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return "", "", errors.New(do.Status)
-	}
 	obj := new(structs.AutoGeneratedArtist)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("GET", fmt.Sprintf("/v1/catalog/%s/artists/%s", storefront, artistId), query, token, nil, obj)
 	if err != nil {
 		return "", "", err
 	}
@@ -76,23 +59,8 @@ func FetchArtistItems(artistUrl, token, relationship, lang string) ([]ArtistItem
 	var items []ArtistItem
 
 	for {
-		req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/artists/%s/%s?limit=100&offset=%d&l=%s", storefront, artistId, relationship, Num, lang), nil)
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-		req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-		req.Header.Set("Origin", "https://music.apple.com")
-		do, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return nil, err
-		}
-		defer do.Body.Close()
-		if do.StatusCode != http.StatusOK {
-			return nil, errors.New(do.Status)
-		}
 		obj := new(structs.AutoGeneratedArtist)
-		err = json.NewDecoder(do.Body).Decode(&obj)
+		err := DefaultClient.FetchJSON("GET", fmt.Sprintf("/v1/catalog/%s/artists/%s/%s?limit=100&offset=%d&l=%s", storefront, artistId, relationship, Num, lang), nil, token, nil, obj)
 		if err != nil {
 			return nil, err
 		}
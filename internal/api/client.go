@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const userAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
+
+// StatusError is returned when the catalog answers with a non-200 status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return e.Status
+}
+
+// Client is the shared HTTP client for catalog requests. It retries 429 and
+// 5xx responses with jittered backoff, spaces requests out by MinInterval and
+// refreshes the bearer token once when the API answers 401.
+type Client struct {
+	// BaseURL is the catalog API root, WebURL the web player the anonymous
+	// token is scraped from. Both can point at a local server for testing.
+	BaseURL     string
+	WebURL      string
+	HTTPClient  *http.Client
+	MaxRetries  int
+	MinInterval time.Duration
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	mu       sync.Mutex
+	token    string
+	lastCall time.Time
+}
+
+// DefaultClient is used by the package level request functions.
+var DefaultClient = NewClient()
+
+func NewClient() *Client {
+	return &Client{
+		BaseURL:     "https://amp-api.music.apple.com",
+		WebURL:      "https://music.apple.com",
+		HTTPClient:  &http.Client{Timeout: 30 * time.Second},
+		MaxRetries:  5,
+		MinInterval: 100 * time.Millisecond,
+		BaseDelay:   time.Second,
+		MaxDelay:    60 * time.Second,
+	}
+}
+
+// Do sends req with the catalog headers set. The caller owns the returned
+// response body. A token refreshed after a 401 replaces the one passed in for
+// this and every later request.
+func (c *Client) Do(req *http.Request, token string) (*http.Response, error) {
+	token, err := c.resolveToken(token)
+	if err != nil {
+		return nil, err
+	}
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		c.wait()
+		r := req.Clone(req.Context())
+		r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		if r.Header.Get("User-Agent") == "" {
+			r.Header.Set("User-Agent", userAgent)
+		}
+		if r.Header.Get("Origin") == "" {
+			r.Header.Set("Origin", "https://music.apple.com")
+		}
+		resp, err := c.HTTPClient.Do(r)
+		if err != nil {
+			// a canceled or timed out request is not retried
+			if req.Context().Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+				return nil, err
+			}
+			if attempt < c.MaxRetries {
+				if err := sleep(req.Context(), c.backoff(attempt, "")); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !refreshed {
+			drain(resp)
+			refreshed = true
+			attempt--
+			token, err = c.refreshToken()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) && attempt < c.MaxRetries {
+			delay := c.backoff(attempt, resp.Header.Get("Retry-After"))
+			drain(resp)
+			if err := sleep(req.Context(), delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return resp, nil
+	}
+}
+
+// FetchJSON requests path under BaseURL with query merged into the path's own
+// query string and decodes the JSON response into out.
+func (c *Client) FetchJSON(method, path string, query url.Values, token string, header http.Header, out interface{}) error {
+	req, err := http.NewRequest(method, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if query != nil {
+		q := req.URL.Query()
+		for k, v := range query {
+			q[k] = v
+		}
+		req.URL.RawQuery = q.Encode()
+	}
+	do, err := c.Do(req, token)
+	if err != nil {
+		return err
+	}
+	defer do.Body.Close()
+	if do.StatusCode != http.StatusOK {
+		return &StatusError{Code: do.StatusCode, Status: do.Status}
+	}
+	return json.NewDecoder(do.Body).Decode(out)
+}
+
+func (c *Client) resolveToken(token string) (string, error) {
+	c.mu.Lock()
+	cached := c.token
+	c.mu.Unlock()
+	if cached != "" {
+		return cached, nil
+	}
+	if token != "" {
+		return token, nil
+	}
+	return c.refreshToken()
+}
+
+func (c *Client) refreshToken() (string, error) {
+	token, err := c.GetToken()
+	if err != nil {
+		return "", err
+	}
+	if token == "" {
+		return "", errors.New("failed to refresh token")
+	}
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+	return token, nil
+}
+
+// wait blocks until MinInterval has passed since the previous request.
+func (c *Client) wait() {
+	c.mu.Lock()
+	next := c.lastCall.Add(c.MinInterval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.lastCall = next
+	c.mu.Unlock()
+	time.Sleep(time.Until(next))
+}
+
+// backoff returns the delay before retry attempt+1. Retry-After wins when the
+// server sent one, up to MaxDelay; otherwise the delay grows exponentially
+// with full jitter.
+func (c *Client) backoff(attempt int, retryAfter string) time.Duration {
+	if retryAfter != "" {
+		if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+			return min(time.Duration(secs)*time.Second, c.MaxDelay)
+		}
+		if t, err := http.ParseTime(retryAfter); err == nil {
+			return min(max(time.Until(t), 0), c.MaxDelay)
+		}
+	}
+	d := c.BaseDelay << attempt
+	if d <= 0 || d > c.MaxDelay {
+		d = c.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffRetryAfter(t *testing.T) {
+	c := NewClient()
+	c.MaxDelay = 10 * time.Second
+	tests := []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"86400", 10 * time.Second},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := c.backoff(0, tt.retryAfter); got != tt.want {
+			t.Errorf("backoff(0, %q) = %v, want %v", tt.retryAfter, got, tt.want)
+		}
+	}
+	for attempt := 0; attempt < 10; attempt++ {
+		if got := c.backoff(attempt, ""); got < 0 || got > c.MaxDelay {
+			t.Errorf("backoff(%d) = %v, want at most %v", attempt, got, c.MaxDelay)
+		}
+	}
+}
+
+func newTestClient(url string) *Client {
+	c := NewClient()
+	c.BaseURL = url
+	c.MinInterval = 0
+	c.BaseDelay = time.Millisecond
+	c.MaxDelay = 5 * time.Millisecond
+	return c
+}
+
+func TestDoRetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+	c := newTestClient(srv.URL)
+	var out struct{}
+	if err := c.FetchJSON("GET", "/v1/test", nil, "token", nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("server got %d requests, want 3", n)
+	}
+}
+
+func TestDoStopsOnCancel(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c := newTestClient(srv.URL)
+	c.BaseDelay, c.MaxDelay = time.Hour, time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := c.Do(req, "token")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Do = %v, want context.Canceled", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Do kept waiting after the context was canceled")
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"main/internal/structs"
+)
+
+// FixtureTransport records responses into Dir, or serves them back from Dir
+// when Replay is set, so catalog flows can run without network access.
+type FixtureTransport struct {
+	Dir    string
+	Replay bool
+	Next   http.RoundTripper
+}
+
+type fixture struct {
+	Status      int             `json:"status"`
+	ContentType string          `json:"contentType,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+	Text        string          `json:"text,omitempty"`
+}
+
+var fixtureNameRegex = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// FixturePath returns the file a request is recorded to. The name keeps the
+// readable part of the path and a hash of method, path and query.
+func (t *FixtureTransport) FixturePath(req *http.Request) string {
+	key := req.Method + " " + req.URL.Path + "?" + req.URL.Query().Encode()
+	sum := sha1.Sum([]byte(key))
+	name := strings.Trim(fixtureNameRegex.ReplaceAllString(req.URL.Path, "_"), "_")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	if name == "" {
+		name = "root"
+	}
+	return filepath.Join(t.Dir, fmt.Sprintf("%s_%s_%s.json", strings.ToLower(req.Method), name, hex.EncodeToString(sum[:4])))
+}
+
+func (t *FixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := t.FixturePath(req)
+	if t.Replay {
+		return t.replay(req, path)
+	}
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	f := fixture{Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	if json.Valid(body) {
+		f.Body = body
+	} else {
+		f.Text = string(body)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	os.MkdirAll(t.Dir, os.ModePerm)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *FixtureTransport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return newFixtureResponse(req, http.StatusNotFound, "404 Fixture Not Found", "", []byte(path)), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid fixture %s: %w", path, err)
+	}
+	body := []byte(f.Text)
+	if len(f.Body) > 0 {
+		body = f.Body
+	}
+	return newFixtureResponse(req, f.Status, fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)), f.ContentType, body), nil
+}
+
+func newFixtureResponse(req *http.Request, code int, status, contentType string, body []byte) *http.Response {
+	header := make(http.Header)
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{
+		Status:        status,
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// Configure applies the catalog overrides from the config file to
+// DefaultClient.
+func Configure(cfg *structs.ConfigSet) {
+	if cfg.CatalogBaseURL != "" {
+		DefaultClient.BaseURL = strings.TrimSuffix(cfg.CatalogBaseURL, "/")
+	}
+	if cfg.WebBaseURL != "" {
+		DefaultClient.WebURL = strings.TrimSuffix(cfg.WebBaseURL, "/")
+	}
+	switch cfg.FixtureMode {
+	case "record", "replay":
+		dir := cfg.FixtureDir
+		if dir == "" {
+			dir = "fixtures"
+		}
+		DefaultClient.HTTPClient.Transport = &FixtureTransport{
+			Dir:    dir,
+			Replay: cfg.FixtureMode == "replay",
+			Next:   DefaultClient.HTTPClient.Transport,
+		}
+		if cfg.FixtureMode == "replay" {
+			DefaultClient.MinInterval = 0
+		}
+	}
+}
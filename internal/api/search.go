@@ -1,9 +1,8 @@
 package api
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
 )
 
@@ -51,43 +50,20 @@ type ArtistResults struct {
 
 // Search performs a search query against the Apple Music API.
 func Search(storefront, term, types, language, token string, limit, offset int) (*SearchResp, error) {
-	var err error
-	if token == "" {
-		token, err = GetToken()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/search", storefront), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
-
 	query := url.Values{}
 	query.Set("term", term)
 	query.Set("types", types)
 	query.Set("limit", fmt.Sprintf("%d", limit))
 	query.Set("offset", fmt.Sprintf("%d", offset))
 	query.Set("l", language)
-	req.URL.RawQuery = query.Encode()
-
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer do.Body.Close()
-
-	if do.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status: %s", do.Status)
-	}
 
 	obj := new(SearchResp)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("GET", fmt.Sprintf("/v1/catalog/%s/search", storefront), query, token, nil, obj)
 	if err != nil {
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) {
+			return nil, fmt.Errorf("API request failed with status: %s", statusErr.Status)
+		}
 		return nil, err
 	}
 
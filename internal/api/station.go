@@ -1,7 +1,6 @@
 package api
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,36 +8,12 @@ import (
 )
 
 func GetStationResp(storefront string, id string, language string, token string) (*StationResp, error) {
-	var err error
-	if token == "" {
-		token, err = GetToken()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://amp-api.music.apple.com/v1/catalog/%s/stations/%s", storefront, id), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
 	query := url.Values{}
 	query.Set("omit[resource]", "autos")
 	query.Set("extend", "editorialVideo")
 	query.Set("l", language)
-	req.URL.RawQuery = query.Encode()
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return nil, errors.New(do.Status)
-	}
 	obj := new(StationResp)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("GET", fmt.Sprintf("/v1/catalog/%s/stations/%s", storefront, id), query, token, nil, obj)
 	if err != nil {
 		return nil, err
 	}
@@ -46,62 +21,28 @@ func GetStationResp(storefront string, id string, language string, token string)
 }
 
 func GetStationAssetsUrlAndServerUrl(id string, mutoken string, token string) (string, string, error) {
-	var err error
-	if token == "" {
-		token, err = GetToken()
-		if err != nil {
-			return "", "", err
-		}
-	}
-
-	req, err := http.NewRequest("GET", "https://amp-api.music.apple.com/v1/play/assets", nil)
-	if err != nil {
-		return "", "", err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
-	req.Header.Set("Media-User-Token", mutoken)
+	header := http.Header{}
+	header.Set("Media-User-Token", mutoken)
 	query := url.Values{}
 	//query.Set("omit[resource]", "autos")
 	//query.Set("extend", "editorialVideo")
 	query.Set("id", id)
 	query.Set("kind", "radioStation")
 	query.Set("keyFormat", "web")
-	req.URL.RawQuery = query.Encode()
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", "", err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return "", "", errors.New(do.Status)
-	}
 	obj := new(StationAssets)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("GET", "/v1/play/assets", query, token, header, obj)
 	if err != nil {
 		return "", "", err
 	}
+	if len(obj.Results.Assets) == 0 {
+		return "", "", errors.New("no station assets found")
+	}
 	return obj.Results.Assets[0].Url, obj.Results.Assets[0].KeyServerUrl, nil
 }
 
 func GetStationNextTracks(id, mutoken, language, token string) (*TrackResp, error) {
-	var err error
-	if token == "" {
-		token, err = GetToken()
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://amp-api.music.apple.com/v1/me/stations/next-tracks/%s", id), nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
-	req.Header.Set("Origin", "https://music.apple.com")
-	req.Header.Set("Media-User-Token", mutoken)
+	header := http.Header{}
+	header.Set("Media-User-Token", mutoken)
 	query := url.Values{}
 	query.Set("omit[resource]", "autos")
 	//query.Set("include", "tracks,artists,record-labels")
@@ -109,17 +50,8 @@ func GetStationNextTracks(id, mutoken, language, token string) (*TrackResp, erro
 	query.Set("limit", "10")
 	query.Set("extend", "editorialVideo,extendedAssetUrls")
 	query.Set("l", language)
-	req.URL.RawQuery = query.Encode()
-	do, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer do.Body.Close()
-	if do.StatusCode != http.StatusOK {
-		return nil, errors.New(do.Status)
-	}
 	obj := new(TrackResp)
-	err = json.NewDecoder(do.Body).Decode(&obj)
+	err := DefaultClient.FetchJSON("POST", fmt.Sprintf("/v1/me/stations/next-tracks/%s", id), query, token, header, obj)
 	if err != nil {
 		return nil, err
 	}
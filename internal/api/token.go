@@ -7,12 +7,17 @@ import (
 )
 
 func GetToken() (string, error) {
-	req, err := http.NewRequest("GET", "https://music.apple.com", nil)
+	return DefaultClient.GetToken()
+}
+
+// GetToken scrapes the anonymous developer token from the web player bundle.
+func (c *Client) GetToken() (string, error) {
+	req, err := http.NewRequest("GET", c.WebURL, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -26,12 +31,12 @@ func GetToken() (string, error) {
 	regex := regexp.MustCompile(`/assets/index~[^/]+\.js`)
 	indexJsUri := regex.FindString(string(body))
 
-	req, err = http.NewRequest("GET", "https://music.apple.com"+indexJsUri, nil)
+	req, err = http.NewRequest("GET", c.WebURL+indexJsUri, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err = http.DefaultClient.Do(req)
+	resp, err = c.HTTPClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,317 @@
+// Package audit checks a library written by amdl: that every file still
+// plays, that its tags are complete and, with the catalog at hand, that its
+// albums are complete.
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/internal/api"
+	"main/internal/task"
+	"main/internal/utils"
+	"main/internal/verify"
+
+	"github.com/zhaarey/go-mp4tag"
+)
+
+// Problem kinds.
+const (
+	Corrupt       = "corrupt"
+	Duration      = "duration"
+	Unreadable    = "unreadable-tags"
+	MissingTrack  = "missing-track"
+	TrackTotal    = "track-total"
+	DiscTotal     = "disc-total"
+	MissingCover  = "missing-cover"
+	MissingLyrics = "missing-lyrics"
+	Catalog       = "catalog"
+)
+
+// File is one audio file of the library and what its tags say.
+type File struct {
+	Path        string
+	Codec       string // alac, atmos or aac, from the folder it was found in
+	AlbumID     string
+	ISRC        string
+	Title       string
+	Album       string
+	AlbumArtist string
+	Disc        int
+	DiscTotal   int
+	Track       int
+	TrackTotal  int
+	HasCover    bool
+	HasLyrics   bool
+	tagged      bool
+}
+
+// Problem is one finding. Path is empty for tracks that are missing.
+type Problem struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path,omitempty"`
+	Codec   string `json:"codec,omitempty"`
+	AlbumID string `json:"albumId,omitempty"`
+	TrackID string `json:"trackId,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+// Report is the outcome of an audit.
+type Report struct {
+	Time     time.Time `json:"time"`
+	Files    int       `json:"files"`
+	Albums   int       `json:"albums"`
+	Problems []Problem `json:"problems"`
+}
+
+// Options select the checks beyond playability.
+type Options struct {
+	Cover     bool          // every file should have an embedded cover
+	Lyrics    bool          // files of tracks with lyrics should have them embedded
+	Catalog   bool          // compare albums with the catalog
+	Tolerance time.Duration // allowed difference to the catalog duration
+	FFprobe   string        // checks files other than MP4
+	Discs     bool          // multi-disc albums have a folder per disc
+
+	Storefront string
+	Language   string
+	Token      string
+}
+
+// audioExts are the files an audit looks at.
+var audioExts = map[string]bool{".m4a": true, ".flac": true, ".mp3": true, ".opus": true, ".wav": true}
+
+// Scan lists the audio files below the folders of roots, which maps each
+// folder to the codec of the files in it, and reads their tags.
+func Scan(roots map[string]string) []*File {
+	var files []*File
+	for root, codec := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !audioExts[strings.ToLower(filepath.Ext(path))] {
+				return nil
+			}
+			f := &File{Path: path, Codec: codec}
+			f.readTags()
+			files = append(files, f)
+			return nil
+		})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// readTags fills f from the MP4 tags of the file; other formats keep their
+// zero values.
+func (f *File) readTags() {
+	if strings.ToLower(filepath.Ext(f.Path)) != ".m4a" {
+		return
+	}
+	mp4, err := mp4tag.Open(f.Path)
+	if err != nil {
+		return
+	}
+	defer mp4.Close()
+	tags, err := mp4.Read()
+	if err != nil {
+		return
+	}
+	f.tagged = true
+	if tags.ItunesAlbumID > 0 {
+		f.AlbumID = strconv.Itoa(int(tags.ItunesAlbumID))
+	}
+	f.ISRC = tags.Custom["ISRC"]
+	f.Title = tags.Title
+	f.Album = tags.Album
+	f.AlbumArtist = tags.AlbumArtist
+	f.Disc = int(tags.DiscNumber)
+	f.DiscTotal = int(tags.DiscTotal)
+	f.Track = int(tags.TrackNumber)
+	f.TrackTotal = int(tags.TrackTotal)
+	f.HasCover = len(tags.Pictures) > 0
+	f.HasLyrics = strings.TrimSpace(tags.Lyrics) != ""
+}
+
+// Tagged reports whether the tags of f could be read.
+func (f *File) Tagged() bool {
+	return f.tagged
+}
+
+// Run checks files and returns the report. progress, if not nil, is called
+// before each album and each file without an album.
+func Run(files []*File, opts Options, progress func(string)) *Report {
+	r := &Report{Time: time.Now(), Files: len(files)}
+	albums := make(map[string][]*File)
+	var order []string
+	for _, f := range files {
+		if f.AlbumID == "" {
+			if progress != nil {
+				progress(f.Path)
+			}
+			r.checkFile(f, nil, opts)
+			continue
+		}
+		if _, ok := albums[f.AlbumID]; !ok {
+			order = append(order, f.AlbumID)
+		}
+		albums[f.AlbumID] = append(albums[f.AlbumID], f)
+	}
+	r.Albums = len(order)
+	for _, id := range order {
+		if progress != nil {
+			progress(filepath.Dir(albums[id][0].Path))
+		}
+		r.checkAlbum(id, albums[id], opts)
+	}
+	return r
+}
+
+// checkAlbum checks the files of one album, against the catalog if asked.
+func (r *Report) checkAlbum(id string, files []*File, opts Options) {
+	if !opts.Catalog {
+		for _, f := range files {
+			r.checkFile(f, nil, opts)
+		}
+		return
+	}
+	album := task.NewAlbum(opts.Storefront, id)
+	if err := album.GetResp(opts.Token, opts.Language); err != nil {
+		r.add(Problem{Kind: Catalog, AlbumID: id, Codec: files[0].Codec, Detail: err.Error()})
+		for _, f := range files {
+			r.checkFile(f, nil, opts)
+		}
+		return
+	}
+
+	type position struct{ disc, track int }
+	local := make(map[position][]*File)
+	for _, f := range files {
+		pos := position{f.Disc, f.Track}
+		local[pos] = append(local[pos], f)
+	}
+	discTracks := make(map[int]int)
+	for _, t := range album.Tracks {
+		discTracks[t.Resp.Attributes.DiscNumber]++
+	}
+	codec := files[0].Codec
+	for i := range album.Tracks {
+		t := &album.Tracks[i]
+		if t.Type == "music-videos" {
+			continue
+		}
+		attrs := t.Resp.Attributes
+		pos := position{attrs.DiscNumber, attrs.TrackNumber}
+		found, ok := local[pos]
+		if !ok {
+			r.add(Problem{Kind: MissingTrack, Codec: codec, AlbumID: id, TrackID: t.ID,
+				Detail: fmt.Sprintf("%s: disc %d track %d %q", album.Name, attrs.DiscNumber, attrs.TrackNumber, attrs.Name)})
+			continue
+		}
+		delete(local, pos)
+
+		// the totals the tag writers use, see metadata.FromTrack
+		total := album.Resp.Data[0].Attributes.TrackCount
+		if opts.Discs && t.DiscTotal > 1 {
+			total = discTracks[attrs.DiscNumber]
+		}
+		for _, f := range found {
+			r.checkFile(f, t, opts)
+			if f.tagged && f.TrackTotal != total {
+				r.add(Problem{Kind: TrackTotal, Path: f.Path, Codec: f.Codec, AlbumID: id, TrackID: t.ID,
+					Detail: fmt.Sprintf("track total %d, expected %d", f.TrackTotal, total)})
+			}
+			if f.tagged && f.DiscTotal != t.DiscTotal {
+				r.add(Problem{Kind: DiscTotal, Path: f.Path, Codec: f.Codec, AlbumID: id, TrackID: t.ID,
+					Detail: fmt.Sprintf("disc total %d, expected %d", f.DiscTotal, t.DiscTotal)})
+			}
+		}
+	}
+	// files whose position the catalog does not know
+	for _, f := range files {
+		if _, ok := local[position{f.Disc, f.Track}]; ok {
+			r.checkFile(f, nil, opts)
+		}
+	}
+}
+
+// checkFile checks one file; track is its catalog entry, if known.
+func (r *Report) checkFile(f *File, track *task.Track, opts Options) {
+	p := Problem{Path: f.Path, Codec: f.Codec, AlbumID: f.AlbumID}
+	var expected time.Duration
+	var attrs *api.TrackRespData
+	if track != nil {
+		p.TrackID = track.ID
+		attrs = &track.Resp
+		expected = time.Duration(attrs.Attributes.DurationInMillis) * time.Millisecond
+	}
+	if err := verify.File(f.Path, expected, opts.Tolerance, opts.FFprobe); err != nil && !errors.Is(err, verify.ErrNoProbe) {
+		p.Kind, p.Detail = Corrupt, err.Error()
+		if errors.Is(err, verify.ErrDuration) {
+			p.Kind = Duration
+		}
+		r.add(p)
+		return
+	}
+	if strings.ToLower(filepath.Ext(f.Path)) != ".m4a" {
+		return
+	}
+	if !f.tagged {
+		p.Kind, p.Detail = Unreadable, "tags cannot be read"
+		r.add(p)
+		return
+	}
+	if opts.Cover && !f.HasCover {
+		p.Kind, p.Detail = MissingCover, "no embedded cover"
+		r.add(p)
+	}
+	if opts.Lyrics && attrs != nil && attrs.Attributes.HasLyrics && !f.HasLyrics {
+		p.Kind, p.Detail = MissingLyrics, "no embedded lyrics"
+		r.add(p)
+	}
+}
+
+func (r *Report) add(p Problem) {
+	r.Problems = append(r.Problems, p)
+}
+
+// Broken returns the files that do not play as they should, which only a
+// new download fixes.
+func (r *Report) Broken() []Problem {
+	var broken []Problem
+	for _, p := range r.Problems {
+		if p.Path != "" && (p.Kind == Corrupt || p.Kind == Duration) {
+			broken = append(broken, p)
+		}
+	}
+	return broken
+}
+
+// Fix returns the command that fixes p without a new download: retag for
+// tags and covers, lyrics for missing lyrics, or "" if it takes a download.
+func (p Problem) Fix() string {
+	switch p.Kind {
+	case Unreadable, TrackTotal, DiscTotal, MissingCover:
+		return "retag"
+	case MissingLyrics:
+		return "lyrics"
+	}
+	return ""
+}
+
+// AlbumURL is the catalog URL of album id, to queue it for a new download.
+func AlbumURL(storefront string, id string) string {
+	return fmt.Sprintf("https://music.apple.com/%s/album/%s", storefront, id)
+}
+
+// SetAside renames path to its set-aside name, so the file is downloaded
+// again instead of being skipped as existing. The old file is removed once
+// the new one is in place.
+func SetAside(path string) error {
+	return os.Rename(path, utils.SetAsidePath(path))
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"main/internal/utils"
+)
+
+func TestBrokenAndFix(t *testing.T) {
+	tests := []struct {
+		kind   string
+		path   string
+		broken bool
+		fix    string
+	}{
+		{Corrupt, "a.m4a", true, ""},
+		{Duration, "b.m4a", true, ""},
+		{Unreadable, "c.m4a", false, "retag"},
+		{TrackTotal, "d.m4a", false, "retag"},
+		{DiscTotal, "e.m4a", false, "retag"},
+		{MissingCover, "f.m4a", false, "retag"},
+		{MissingLyrics, "g.m4a", false, "lyrics"},
+		{MissingTrack, "", false, ""},
+		{Catalog, "", false, ""},
+	}
+	r := &Report{}
+	for _, tt := range tests {
+		r.Problems = append(r.Problems, Problem{Kind: tt.kind, Path: tt.path})
+	}
+	broken := make(map[string]bool)
+	for _, p := range r.Broken() {
+		broken[p.Kind] = true
+	}
+	for i, tt := range tests {
+		if broken[tt.kind] != tt.broken {
+			t.Errorf("%s in Broken = %v, want %v", tt.kind, broken[tt.kind], tt.broken)
+		}
+		if got := r.Problems[i].Fix(); got != tt.fix {
+			t.Errorf("%s Fix = %q, want %q", tt.kind, got, tt.fix)
+		}
+	}
+}
+
+func TestSetAside(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "01. Song.m4a")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetAside(path); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := utils.FileExists(path); exists {
+		t.Fatal("set aside file is still in place")
+	}
+
+	// a new download that fails keeps the old file
+	os.WriteFile(utils.PartPath(path), []byte("partial"), 0644)
+	utils.RemoveStaleParts(0, filepath.Dir(path))
+	if data, err := os.ReadFile(utils.SetAsidePath(path)); err != nil || string(data) != "old" {
+		t.Fatalf("set aside file = %q, %v before the replacement is committed", data, err)
+	}
+
+	if err := utils.WriteFile(path, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(utils.SetAsidePath(path)); !os.IsNotExist(err) {
+		t.Errorf("set aside file kept after the replacement was committed: %v", err)
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"strings"
 	"time"
 
+	"main/internal/report"
 	"main/internal/structs"
 	"main/internal/task"
+	"main/internal/utils"
 )
 
 // IsLossySource Determine if source codec is lossy (rough heuristic by extension/codec name).
@@ -25,19 +27,21 @@ func IsLossySource(ext string, codec string) bool {
 }
 
 // BuildFFmpegArgs Build ffmpeg arguments for desired target.
+// The muxer is named explicitly, since outPath may be a part file whose
+// extension ffmpeg cannot guess it from.
 func BuildFFmpegArgs(ffmpegPath, inPath, outPath, targetFmt, extraArgs string) ([]string, error) {
 	args := []string{"-y", "-i", inPath, "-vn"}
 	switch targetFmt {
 	case "flac":
-		args = append(args, "-c:a", "flac")
+		args = append(args, "-c:a", "flac", "-f", "flac")
 	case "mp3":
 		// VBR quality 2 ~ high quality
-		args = append(args, "-c:a", "libmp3lame", "-qscale:a", "2")
+		args = append(args, "-c:a", "libmp3lame", "-qscale:a", "2", "-f", "mp3")
 	case "opus":
 		// Medium/high quality
-		args = append(args, "-c:a", "libopus", "-b:a", "192k", "-vbr", "on")
+		args = append(args, "-c:a", "libopus", "-b:a", "192k", "-vbr", "on", "-f", "opus")
 	case "wav":
-		args = append(args, "-c:a", "pcm_s16le")
+		args = append(args, "-c:a", "pcm_s16le", "-f", "wav")
 	case "copy":
 		// Just container copy (probably pointless for same container)
 		args = append(args, "-c", "copy")
@@ -53,30 +57,34 @@ func BuildFFmpegArgs(ffmpegPath, inPath, outPath, targetFmt, extraArgs string) (
 }
 
 // ConvertIfNeeded Perform conversion if enabled.
-func ConvertIfNeeded(track *task.Track, cfg *structs.ConfigSet) {
+// The output is written to a part file, handed to finish if it is not nil
+// and then renamed to its final name. An error of finish drops the output,
+// keeps the original and is returned; other failures only leave the
+// original in place.
+func ConvertIfNeeded(track *task.Track, cfg *structs.ConfigSet, finish func(path string) error) error {
 	if !cfg.ConvertAfterDownload {
-		return
+		return nil
 	}
 	if cfg.ConvertFormat == "" {
-		return
+		return nil
 	}
 	srcPath := track.SavePath
 	if srcPath == "" {
-		return
+		return nil
 	}
 	ext := strings.ToLower(filepath.Ext(srcPath))
 	targetFmt := strings.ToLower(cfg.ConvertFormat)
 
 	// Map extension for output
 	if targetFmt == "copy" {
-		fmt.Println("Convert (copy) requested; skipping because it produces no new format.")
-		return
+		fmt.Fprintln(report.Out, "Convert (copy) requested; skipping because it produces no new format.")
+		return nil
 	}
 
 	if cfg.ConvertSkipIfSourceMatch {
 		if ext == "."+targetFmt {
-			fmt.Printf("Conversion skipped (already %s)\n", targetFmt)
-			return
+			fmt.Fprintf(report.Out, "Conversion skipped (already %s)\n", targetFmt)
+			return nil
 		}
 	}
 
@@ -86,48 +94,61 @@ func ConvertIfNeeded(track *task.Track, cfg *structs.ConfigSet) {
 	// Handle lossy -> lossless cases: optionally skip or warn
 	if (targetFmt == "flac" || targetFmt == "wav") && IsLossySource(ext, track.Codec) {
 		if cfg.ConvertSkipLossyToLossless {
-			fmt.Println("Skipping conversion: source appears lossy and target is lossless; configured to skip.")
-			return
+			fmt.Fprintln(report.Out, "Skipping conversion: source appears lossy and target is lossless; configured to skip.")
+			return nil
 		}
 		if cfg.ConvertWarnLossyToLossless {
-			fmt.Println("Warning: Converting lossy source to lossless container will not improve quality.")
+			fmt.Fprintln(report.Out, "Warning: Converting lossy source to lossless container will not improve quality.")
 		}
 	}
 
 	if _, err := exec.LookPath(cfg.FFmpegPath); err != nil {
-		fmt.Printf("ffmpeg not found at '%s'; skipping conversion.\n", cfg.FFmpegPath)
-		return
+		fmt.Fprintf(report.Out, "ffmpeg not found at '%s'; skipping conversion.\n", cfg.FFmpegPath)
+		return nil
 	}
 
-	args, err := BuildFFmpegArgs(cfg.FFmpegPath, srcPath, outPath, targetFmt, cfg.ConvertExtraArgs)
+	partPath := utils.PartPath(outPath)
+	args, err := BuildFFmpegArgs(cfg.FFmpegPath, srcPath, partPath, targetFmt, cfg.ConvertExtraArgs)
 	if err != nil {
-		fmt.Println("Conversion config error:", err)
-		return
+		fmt.Fprintln(report.Out, "Conversion config error:", err)
+		return nil
 	}
 
-	fmt.Printf("Converting -> %s ...\n", targetFmt)
+	fmt.Fprintf(report.Out, "Converting -> %s ...\n", targetFmt)
 	cmd := exec.Command(cfg.FFmpegPath, args...)
 	cmd.Stdout = nil
 	cmd.Stderr = nil
 	start := time.Now()
 	if err := cmd.Run(); err != nil {
-		fmt.Println("Conversion failed:", err)
+		os.Remove(partPath)
+		fmt.Fprintln(report.Out, "Conversion failed:", err)
 		// leave original
-		return
+		return nil
 	}
-	fmt.Printf("Conversion completed in %s: %s\n", time.Since(start).Truncate(time.Millisecond), filepath.Base(outPath))
+	if finish != nil {
+		if err := finish(partPath); err != nil {
+			os.Remove(partPath)
+			return err
+		}
+	}
+	if err := utils.CommitPart(outPath); err != nil {
+		fmt.Fprintln(report.Out, "Conversion failed:", err)
+		return nil
+	}
+	fmt.Fprintf(report.Out, "Conversion completed in %s: %s\n", time.Since(start).Truncate(time.Millisecond), filepath.Base(outPath))
 
 	if !cfg.ConvertKeepOriginal {
 		if err := os.Remove(srcPath); err != nil {
-			fmt.Println("Failed to remove original after conversion:", err)
+			fmt.Fprintln(report.Out, "Failed to remove original after conversion:", err)
 		} else {
 			track.SavePath = outPath
 			track.SaveName = filepath.Base(outPath)
-			fmt.Println("Original removed.")
+			fmt.Fprintln(report.Out, "Original removed.")
 		}
 	} else {
 		// Keep both but point track to new file (optional decision)
 		track.SavePath = outPath
 		track.SaveName = filepath.Base(outPath)
 	}
+	return nil
 }
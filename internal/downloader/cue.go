@@ -0,0 +1,124 @@
+package downloader
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"main/internal/history"
+	"main/internal/naming"
+	"main/internal/report"
+	"main/internal/structs"
+	"main/internal/task"
+	"main/internal/utils"
+)
+
+// writeDiscPlaylists writes an M3U8 playlist and a cue sheet for every disc
+// of album, next to its tracks. Tracks that are not on disk are left out.
+func writeDiscPlaylists(album *task.Album, hist *history.Store, codec string, cfg *structs.ConfigSet) {
+	discs := make(map[int][]*task.Track)
+	for i := range album.Tracks {
+		track := &album.Tracks[i]
+		if track.Type == "music-videos" {
+			continue
+		}
+		if track.SavePath == "" {
+			// finished in an earlier run
+			if entry, ok := hist.Lookup(track.ID, codec); ok {
+				track.SavePath = entry.Path
+			}
+		}
+		if exists, err := utils.FileExists(track.SavePath); err != nil || !exists {
+			continue
+		}
+		disc := track.Resp.Attributes.DiscNumber
+		discs[disc] = append(discs[disc], track)
+	}
+
+	var numbers []int
+	for disc := range discs {
+		numbers = append(numbers, disc)
+	}
+	sort.Ints(numbers)
+	for _, disc := range numbers {
+		tracks := discs[disc]
+		sort.SliceStable(tracks, func(i, j int) bool {
+			return tracks[i].Resp.Attributes.TrackNumber < tracks[j].Resp.Attributes.TrackNumber
+		})
+		dir := filepath.Dir(tracks[0].SavePath)
+		name := utils.LimitString(album.Name, cfg.LimitMax)
+		if tracks[0].DiscTotal > 1 {
+			name = fmt.Sprintf("%s (Disc %d)", name, disc)
+		}
+		base := filepath.Join(dir, naming.CleanName(name))
+		if err := utils.WriteFile(base+".m3u8", []byte(discM3u8(tracks, dir))); err != nil {
+			fmt.Fprintln(report.Out, "Failed to write disc playlist:", err)
+		}
+		if err := utils.WriteFile(base+".cue", []byte(discCue(album, tracks, dir, disc))); err != nil {
+			fmt.Fprintln(report.Out, "Failed to write cue sheet:", err)
+		}
+	}
+}
+
+// discM3u8 is an extended M3U playlist of tracks with paths relative to dir.
+func discM3u8(tracks []*task.Track, dir string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, track := range tracks {
+		attrs := track.Resp.Attributes
+		fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n", attrs.DurationInMillis/1000, attrs.ArtistName, attrs.Name)
+		b.WriteString(relPath(dir, track.SavePath) + "\n")
+	}
+	return b.String()
+}
+
+// discCue is a cue sheet with one FILE per track, as players expect for
+// albums kept as separate files.
+func discCue(album *task.Album, tracks []*task.Track, dir string, disc int) string {
+	attrs := tracks[0].AlbumData.Attributes
+	var b strings.Builder
+	if len(attrs.GenreNames) > 0 {
+		fmt.Fprintf(&b, "REM GENRE %s\n", cueString(attrs.GenreNames[0]))
+	}
+	if attrs.ReleaseDate != "" {
+		fmt.Fprintf(&b, "REM DATE %s\n", attrs.ReleaseDate)
+	}
+	if len(attrs.Upc) == 13 {
+		fmt.Fprintf(&b, "CATALOG %s\n", attrs.Upc)
+	}
+	fmt.Fprintf(&b, "PERFORMER %s\n", cueString(attrs.ArtistName))
+	fmt.Fprintf(&b, "TITLE %s\n", cueString(album.Name))
+	fmt.Fprintf(&b, "REM DISCNUMBER %d\n", disc)
+	fmt.Fprintf(&b, "REM TOTALDISCS %d\n", tracks[0].DiscTotal)
+	for _, track := range tracks {
+		fileType := "WAVE"
+		if strings.EqualFold(filepath.Ext(track.SavePath), ".mp3") {
+			fileType = "MP3"
+		}
+		t := track.Resp.Attributes
+		fmt.Fprintf(&b, "FILE %s %s\n", cueString(relPath(dir, track.SavePath)), fileType)
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", t.TrackNumber)
+		fmt.Fprintf(&b, "    TITLE %s\n", cueString(t.Name))
+		fmt.Fprintf(&b, "    PERFORMER %s\n", cueString(t.ArtistName))
+		if len(t.Isrc) == 12 {
+			fmt.Fprintf(&b, "    ISRC %s\n", t.Isrc)
+		}
+		b.WriteString("    INDEX 01 00:00:00\n")
+	}
+	return b.String()
+}
+
+// cueString quotes s for a cue sheet, which has no escape for quotes.
+func cueString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "'") + `"`
+}
+
+// relPath returns path relative to dir with "/" separators.
+func relPath(dir string, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		rel = path
+	}
+	return filepath.ToSlash(rel)
+}
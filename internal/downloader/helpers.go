@@ -9,12 +9,12 @@ import (
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 
+	"main/internal/report"
 	"main/internal/structs"
 
 	"github.com/grafov/m3u8"
@@ -28,12 +28,12 @@ func CheckM3u8(b string, f string, cfg *structs.ConfigSet) (string, error) {
 		adamID := b
 		conn, err := net.Dial("tcp", cfg.GetM3u8Port)
 		if err != nil {
-			fmt.Println("Error connecting to device:", err)
+			fmt.Fprintln(report.Out, "Error connecting to device:", err)
 			return "none", err
 		}
 		defer conn.Close()
 		if f == "song" {
-			fmt.Println("Connected to device")
+			fmt.Fprintln(report.Out, "Connected to device")
 		}
 
 		adamIDBuffer := []byte(adamID)
@@ -41,35 +41,45 @@ func CheckM3u8(b string, f string, cfg *structs.ConfigSet) (string, error) {
 
 		_, err = conn.Write(lengthBuffer)
 		if err != nil {
-			fmt.Println("Error writing length to device:", err)
+			fmt.Fprintln(report.Out, "Error writing length to device:", err)
 			return "none", err
 		}
 
 		_, err = conn.Write(adamIDBuffer)
 		if err != nil {
-			fmt.Println("Error writing adamID to device:", err)
+			fmt.Fprintln(report.Out, "Error writing adamID to device:", err)
 			return "none", err
 		}
 
 		response, err := bufio.NewReader(conn).ReadBytes('\n')
 		if err != nil {
-			fmt.Println("Error reading response from device:", err)
+			fmt.Fprintln(report.Out, "Error reading response from device:", err)
 			return "none", err
 		}
 
 		response = bytes.TrimSpace(response)
 		if len(response) > 0 {
 			if f == "song" {
-				fmt.Println("Received URL:", string(response))
+				fmt.Fprintln(report.Out, "Received URL:", string(response))
 			}
 			EnhancedHls = string(response)
 		} else {
-			fmt.Println("Received an empty response")
+			fmt.Fprintln(report.Out, "Received an empty response")
 		}
 	}
 	return EnhancedHls, nil
 }
 
+// CodecName returns the codec label used for folders and the download history.
+func CodecName(dl_atmos bool, dl_aac bool) string {
+	if dl_atmos {
+		return "ATMOS"
+	} else if dl_aac {
+		return "AAC"
+	}
+	return "ALAC"
+}
+
 func FormatAvailability(available bool, quality string) string {
 	if !available {
 		return "Not Available"
@@ -106,12 +116,12 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 		return master.Variants[i].AverageBandwidth > master.Variants[j].AverageBandwidth
 	})
 	if debug_mode && more_mode {
-		fmt.Println("\nDebug: All Available Variants:")
+		fmt.Fprintln(report.Out, "\nDebug: All Available Variants:")
 		var data [][]string
 		for _, variant := range master.Variants {
 			data = append(data, []string{variant.Codecs, variant.Audio, fmt.Sprint(variant.Bandwidth)})
 		}
-		table := tablewriter.NewWriter(os.Stdout)
+		table := tablewriter.NewWriter(report.Out)
 		table.SetHeader([]string{"Codec", "Audio", "Bandwidth"})
 		table.SetAutoMergeCells(true)
 		table.SetRowLine(true)
@@ -179,14 +189,14 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 			}
 		}
 
-		fmt.Println("Available Audio Formats:")
-		fmt.Println("------------------------")
-		fmt.Printf("AAC             : %s\n", FormatAvailability(hasAAC, aacQuality))
-		fmt.Printf("Lossless        : %s\n", FormatAvailability(hasLossless, losslessQuality))
-		fmt.Printf("Hi-Res Lossless : %s\n", FormatAvailability(hasHiRes, hiResQuality))
-		fmt.Printf("Dolby Atmos     : %s\n", FormatAvailability(hasAtmos, atmosQuality))
-		fmt.Printf("Dolby Audio     : %s\n", FormatAvailability(hasDolbyAudio, dolbyAudioQuality))
-		fmt.Println("------------------------")
+		fmt.Fprintln(report.Out, "Available Audio Formats:")
+		fmt.Fprintln(report.Out, "------------------------")
+		fmt.Fprintf(report.Out, "AAC             : %s\n", FormatAvailability(hasAAC, aacQuality))
+		fmt.Fprintf(report.Out, "Lossless        : %s\n", FormatAvailability(hasLossless, losslessQuality))
+		fmt.Fprintf(report.Out, "Hi-Res Lossless : %s\n", FormatAvailability(hasHiRes, hiResQuality))
+		fmt.Fprintf(report.Out, "Dolby Atmos     : %s\n", FormatAvailability(hasAtmos, atmosQuality))
+		fmt.Fprintf(report.Out, "Dolby Audio     : %s\n", FormatAvailability(hasDolbyAudio, dolbyAudioQuality))
+		fmt.Fprintln(report.Out, "------------------------")
 
 		return "", "", nil
 	}
@@ -195,7 +205,7 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 		if dl_atmos {
 			if variant.Codecs == "ec-3" && strings.Contains(variant.Audio, "atmos") {
 				if debug_mode && !more_mode {
-					fmt.Printf("Debug: Found Dolby Atmos variant - %s (Bitrate: %d Kbps)\n",
+					fmt.Fprintf(report.Out, "Debug: Found Dolby Atmos variant - %s (Bitrate: %d Kbps)\n",
 						variant.Audio, variant.Bandwidth/1000)
 				}
 				split := strings.Split(variant.Audio, "-")
@@ -216,7 +226,7 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 
 					if bRate <= cfg.AtmosMax {
 						if !debug_mode && !more_mode {
-							fmt.Printf("%s\n", variant.Audio)
+							fmt.Fprintf(report.Out, "%s\n", variant.Audio)
 						}
 						streamUrlTemp, err := masterUrl.Parse(variant.URI)
 						if err != nil {
@@ -229,7 +239,7 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 				}
 			} else if variant.Codecs == "ac-3" { // Add Dolby Audio support
 				if debug_mode && !more_mode {
-					fmt.Printf("Debug: Found Dolby Audio variant - %s (Bitrate: %d Kbps)\n",
+					fmt.Fprintf(report.Out, "Debug: Found Dolby Audio variant - %s (Bitrate: %d Kbps)\n",
 						variant.Audio, variant.Bandwidth/1000)
 				}
 				streamUrlTemp, err := masterUrl.Parse(variant.URI)
@@ -244,13 +254,13 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 		} else if dl_aac {
 			if variant.Codecs == "mp4a.40.2" {
 				if debug_mode && !more_mode {
-					fmt.Printf("Debug: Found AAC variant - %s (Bitrate: %d)\n", variant.Audio, variant.Bandwidth)
+					fmt.Fprintf(report.Out, "Debug: Found AAC variant - %s (Bitrate: %d)\n", variant.Audio, variant.Bandwidth)
 				}
 				aacregex := regexp.MustCompile(`audio-stereo-\d+`)
 				replaced := aacregex.ReplaceAllString(variant.Audio, "aac")
 				if replaced == cfg.AacType {
 					if !debug_mode && !more_mode {
-						fmt.Printf("%s\n", variant.Audio)
+						fmt.Fprintf(report.Out, "%s\n", variant.Audio)
 					}
 					streamUrlTemp, err := masterUrl.Parse(variant.URI)
 					if err != nil {
@@ -272,7 +282,7 @@ func ExtractMedia(b string, more_mode bool, cfg *structs.ConfigSet, dl_atmos boo
 				}
 				if length_int <= cfg.AlacMax {
 					if !debug_mode && !more_mode {
-						fmt.Printf("%s-bit / %s Hz\n", split[length-1], split[length-2])
+						fmt.Fprintf(report.Out, "%s-bit / %s Hz\n", split[length-1], split[length-2])
 					}
 					streamUrlTemp, err := masterUrl.Parse(variant.URI)
 					if err != nil {
@@ -344,7 +354,7 @@ func ExtractVideo(c string, cfg *structs.ConfigSet) (string, error) {
 				if err != nil {
 					return "", err
 				}
-				fmt.Println("Video: " + variant.Resolution + "-" + variant.VideoRange)
+				fmt.Fprintln(report.Out, "Video: "+variant.Resolution+"-"+variant.VideoRange)
 				break
 			}
 		}
@@ -15,6 +15,10 @@ import (
 
 	"main/internal/api"
 	"main/internal/downloader/runv3"
+	"main/internal/lyrics"
+	"main/internal/metadata"
+	"main/internal/naming"
+	"main/internal/report"
 	"main/internal/structs"
 	"main/internal/tagger"
 	"main/internal/task"
@@ -23,40 +27,48 @@ import (
 	"github.com/grafov/m3u8"
 )
 
-var forbiddenNames = regexp.MustCompile(`[/\\<>:"|?*]`)
-
-func MvDownloader(adamID string, saveDir string, token string, storefront string, mediaUserToken string, track *task.Track, cfg *structs.ConfigSet, counter *structs.Counter) error {
+// MvDownloader downloads the music video adamID into saveDir and returns
+// the path of its .mp4, or "" if nothing was written.
+func MvDownloader(adamID string, saveDir string, token string, storefront string, mediaUserToken string, track *task.Track, cfg *structs.ConfigSet, counter *structs.Counter) (string, error) {
 	MVInfo, err := api.GetMusicVideoResp(storefront, adamID, cfg.Language, token)
 	if err != nil {
-		fmt.Println("\u26A0 Failed to get MV manifest:", err)
-		return nil
-	}
-
-	if strings.HasSuffix(saveDir, ".") {
-		saveDir = strings.ReplaceAll(saveDir, ".", "")
+		fmt.Fprintln(report.Out, "\u26A0 Failed to get MV manifest:", err)
+		return "", nil
 	}
-	saveDir = strings.TrimSpace(saveDir)
 
 	vidPath := filepath.Join(saveDir, fmt.Sprintf("%s_vid.mp4", adamID))
 	audPath := filepath.Join(saveDir, fmt.Sprintf("%s_aud.mp4", adamID))
-	mvSaveName := fmt.Sprintf("%s (%s)", MVInfo.Data[0].Attributes.Name, adamID)
+	meta := metadata.FromMusicVideo(&MVInfo.Data[0], track, cfg.UseSongInfoForPlaylist, track != nil && discFolders(track, cfg))
+	mvSaveName := naming.CleanName(fmt.Sprintf("%s (%s)", meta.Title, adamID))
 	if track != nil {
-		mvSaveName = fmt.Sprintf("%02d. %s", track.TaskNum, MVInfo.Data[0].Attributes.Name)
+		// videos of albums and playlists are named like their tracks
+		fields := trackFields(track, meta, cfg)
+		fields.Kind = "music-video"
+		mvSaveName, err = naming.Render(cfg.SongFileFormat, fields)
+		if err != nil {
+			return "", fmt.Errorf("invalid song-file-format: %w", err)
+		}
 	}
 
-	mvOutPath := filepath.Join(saveDir, fmt.Sprintf("%s.mp4", forbiddenNames.ReplaceAllString(mvSaveName, "_")))
+	mvSaveName = naming.File(saveDir, mvSaveName, adamID)
+	mvOutPath := filepath.Join(saveDir, mvSaveName+".mp4")
 
-	fmt.Println(MVInfo.Data[0].Attributes.Name)
+	fmt.Fprintln(report.Out, meta.Title)
+	if DryRun {
+		fmt.Fprintln(report.Out, "Would write", mvOutPath)
+		return "", nil
+	}
+	os.MkdirAll(filepath.Dir(mvOutPath), os.ModePerm)
 
 	exists, _ := utils.FileExists(mvOutPath)
 	if exists {
-		fmt.Println("MV already exists locally.")
-		return nil
+		fmt.Fprintln(report.Out, "MV already exists locally.")
+		return mvOutPath, nil
 	}
 
 	mvm3u8url, _, _, _ := runv3.GetWebplayback(adamID, token, mediaUserToken, true)
 	if mvm3u8url == "" {
-		return errors.New("media-user-token may wrong or expired")
+		return "", errors.New("media-user-token may wrong or expired")
 	}
 
 	os.MkdirAll(saveDir, os.ModePerm)
@@ -69,68 +81,17 @@ func MvDownloader(adamID string, saveDir string, token string, storefront string
 	_ = runv3.ExtMvData(audiokeyAndUrls, audPath)
 	defer os.Remove(audPath)
 
-	tags := []string{
-		"tool=",
-		fmt.Sprintf("artist=%s", MVInfo.Data[0].Attributes.ArtistName),
-		fmt.Sprintf("title=%s", MVInfo.Data[0].Attributes.Name),
-		fmt.Sprintf("genre=%s", MVInfo.Data[0].Attributes.GenreNames[0]),
-		fmt.Sprintf("created=%s", MVInfo.Data[0].Attributes.ReleaseDate),
-		fmt.Sprintf("ISRC=%s", MVInfo.Data[0].Attributes.Isrc),
-	}
-
-	switch MVInfo.Data[0].Attributes.ContentRating {
-	case "explicit":
-		tags = append(tags, "rating=1")
-	case "clean":
-		tags = append(tags, "rating=2")
-	default:
-		tags = append(tags, "rating=0")
-	}
-
-	if track != nil {
-		if track.PreType == "playlists" && !cfg.UseSongInfoForPlaylist {
-			tags = append(tags, "disk=1/1")
-			tags = append(tags, fmt.Sprintf("album=%s", track.PlaylistData.Attributes.Name))
-			tags = append(tags, fmt.Sprintf("track=%d", track.TaskNum))
-			tags = append(tags, fmt.Sprintf("tracknum=%d/%d", track.TaskNum, track.TaskTotal))
-			tags = append(tags, fmt.Sprintf("album_artist=%s", track.PlaylistData.Attributes.ArtistName))
-			tags = append(tags, fmt.Sprintf("performer=%s", track.Resp.Attributes.ArtistName))
-		} else if track.PreType == "playlists" && cfg.UseSongInfoForPlaylist {
-			tags = append(tags, fmt.Sprintf("album=%s", track.AlbumData.Attributes.Name))
-			tags = append(tags, fmt.Sprintf("disk=%d/%d", track.Resp.Attributes.DiscNumber, track.DiscTotal))
-			tags = append(tags, fmt.Sprintf("track=%d", track.Resp.Attributes.TrackNumber))
-			tags = append(tags, fmt.Sprintf("tracknum=%d/%d", track.Resp.Attributes.TrackNumber, track.AlbumData.Attributes.TrackCount))
-			tags = append(tags, fmt.Sprintf("album_artist=%s", track.AlbumData.Attributes.ArtistName))
-			tags = append(tags, fmt.Sprintf("performer=%s", track.Resp.Attributes.ArtistName))
-			tags = append(tags, fmt.Sprintf("copyright=%s", track.AlbumData.Attributes.Copyright))
-			tags = append(tags, fmt.Sprintf("UPC=%s", track.AlbumData.Attributes.Upc))
-		} else {
-			tags = append(tags, fmt.Sprintf("album=%s", track.AlbumData.Attributes.Name))
-			tags = append(tags, fmt.Sprintf("disk=%d/%d", track.Resp.Attributes.DiscNumber, track.DiscTotal))
-			tags = append(tags, fmt.Sprintf("track=%d", track.Resp.Attributes.TrackNumber))
-			tags = append(tags, fmt.Sprintf("tracknum=%d/%d", track.Resp.Attributes.TrackNumber, track.AlbumData.Attributes.TrackCount))
-			tags = append(tags, fmt.Sprintf("album_artist=%s", track.AlbumData.Attributes.ArtistName))
-			tags = append(tags, fmt.Sprintf("performer=%s", track.Resp.Attributes.ArtistName))
-			tags = append(tags, fmt.Sprintf("copyright=%s", track.AlbumData.Attributes.Copyright))
-			tags = append(tags, fmt.Sprintf("UPC=%s", track.AlbumData.Attributes.Upc))
-		}
-	} else {
-		tags = append(tags, fmt.Sprintf("album=%s", MVInfo.Data[0].Attributes.AlbumName))
-		tags = append(tags, fmt.Sprintf("disk=%d", MVInfo.Data[0].Attributes.DiscNumber))
-		tags = append(tags, fmt.Sprintf("track=%d", MVInfo.Data[0].Attributes.TrackNumber))
-		tags = append(tags, fmt.Sprintf("tracknum=%d", MVInfo.Data[0].Attributes.TrackNumber))
-		tags = append(tags, fmt.Sprintf("performer=%s", MVInfo.Data[0].Attributes.ArtistName))
-	}
+	tags := mvTags(meta)
 
 	var covPath string
 	// if true { // Logic from main.go
-	thumbURL := MVInfo.Data[0].Attributes.Artwork.URL
-	baseThumbName := forbiddenNames.ReplaceAllString(mvSaveName, "_") + "_thumbnail"
+	thumbURL := meta.ArtworkURL
+	baseThumbName := mvSaveName + "_thumbnail"
 	// Need writeCover to be accessible. It is in tagger package now.
 	// AND WriteCover needs cfg.
 	covPath, err = tagger.WriteCover(saveDir, baseThumbName, thumbURL, cfg)
 	if err != nil {
-		fmt.Println("Failed to save MV thumbnail:", err)
+		fmt.Fprintln(report.Out, "Failed to save MV thumbnail:", err)
 	} else {
 		tags = append(tags, fmt.Sprintf("cover=%s", covPath))
 	}
@@ -138,14 +99,101 @@ func MvDownloader(adamID string, saveDir string, token string, storefront string
 	defer os.Remove(covPath)
 
 	tagsString := strings.Join(tags, ":")
-	muxCmd := exec.Command("MP4Box", "-itags", tagsString, "-quiet", "-add", vidPath, "-add", audPath, "-keep-utc", "-new", mvOutPath)
-	fmt.Printf("MV Remuxing...")
+	muxArgs := []string{"-itags", tagsString, "-quiet", "-add", vidPath, "-add", audPath}
+	if cfg.MVSubtitles != "" {
+		subPath, err := mvSubtitles(meta.ISRC, filepath.Join(saveDir, adamID+"_lyrics"), storefront, token, mediaUserToken, cfg)
+		if err != nil {
+			fmt.Fprintln(report.Out, "No MV subtitles:", err)
+		} else {
+			defer os.Remove(subPath)
+			muxArgs = append(muxArgs, "-add", subPath+":name=Lyrics")
+		}
+	}
+	muxArgs = append(muxArgs, "-keep-utc", "-new", utils.PartPath(mvOutPath))
+	muxCmd := exec.Command("MP4Box", muxArgs...)
+	fmt.Fprintf(report.Out, "MV Remuxing...")
 	if err := muxCmd.Run(); err != nil {
-		fmt.Printf("MV mux failed: %v\n", err)
-		return err
+		os.Remove(utils.PartPath(mvOutPath))
+		fmt.Fprintf(report.Out, "MV mux failed: %v\n", err)
+		return "", err
+	}
+	if err := utils.CommitPart(mvOutPath); err != nil {
+		fmt.Fprintf(report.Out, "MV mux failed: %v\n", err)
+		return "", err
+	}
+	fmt.Fprintf(report.Out, "\rMV Remuxed.   \n")
+	return mvOutPath, nil
+}
+
+// mvSubtitles writes the synced lyrics of the song recorded under isrc to
+// base with the extension of mv-subtitles and returns its path, for muxing
+// into the video.
+func mvSubtitles(isrc string, base string, storefront string, token string, mediaUserToken string, cfg *structs.ConfigSet) (string, error) {
+	if cfg.MVSubtitles != "srt" && cfg.MVSubtitles != "vtt" {
+		return "", fmt.Errorf("mv-subtitles must be srt or vtt, not %q", cfg.MVSubtitles)
+	}
+	if isrc == "" {
+		return "", errors.New("the video has no ISRC")
+	}
+	songs, err := api.GetSongsByIsrc(storefront, isrc, cfg.Language, token)
+	if err != nil {
+		return "", err
+	}
+	if len(songs.Data) == 0 {
+		return "", errors.New("no song with the ISRC of the video")
+	}
+	ttml, err := lyrics.Get(storefront, songs.Data[0].ID, cfg.LrcType, cfg.Language, "ttml", token, mediaUserToken)
+	if err != nil {
+		return "", err
+	}
+	text, err := lyrics.Convert(ttml, cfg.MVSubtitles)
+	if err != nil {
+		return "", err
+	}
+	path := base + "." + lyrics.Ext(cfg.MVSubtitles)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// mvTags returns the MP4Box -itags entries for meta.
+func mvTags(meta *metadata.TrackMetadata) []string {
+	tags := []string{
+		"tool=",
+		fmt.Sprintf("artist=%s", meta.Artist),
+		fmt.Sprintf("title=%s", meta.Title),
+		fmt.Sprintf("genre=%s", meta.Genre),
+		fmt.Sprintf("created=%s", meta.ReleaseTime),
+		fmt.Sprintf("ISRC=%s", meta.ISRC),
+		fmt.Sprintf("performer=%s", meta.Artist),
+	}
+	switch meta.Advisory {
+	case "explicit":
+		tags = append(tags, "rating=1")
+	case "clean":
+		tags = append(tags, "rating=2")
+	default:
+		tags = append(tags, "rating=0")
+	}
+	tags = append(tags, fmt.Sprintf("album=%s", meta.Album))
+	if meta.DiscNumber > 0 {
+		tags = append(tags, "disk="+tagger.Position(meta.DiscNumber, meta.DiscTotal))
+	}
+	if meta.TrackNumber > 0 {
+		tags = append(tags, fmt.Sprintf("track=%d", meta.TrackNumber))
+		tags = append(tags, "tracknum="+tagger.Position(meta.TrackNumber, meta.TrackTotal))
+	}
+	if meta.AlbumArtist != "" {
+		tags = append(tags, fmt.Sprintf("album_artist=%s", meta.AlbumArtist))
+	}
+	if meta.Copyright != "" {
+		tags = append(tags, fmt.Sprintf("copyright=%s", meta.Copyright))
+	}
+	if meta.UPC != "" {
+		tags = append(tags, fmt.Sprintf("UPC=%s", meta.UPC))
 	}
-	fmt.Printf("\rMV Remuxed.   \n")
-	return nil
+	return tags
 }
 
 func ExtractMvAudio(c string, cfg *structs.ConfigSet) (string, error) {
@@ -223,6 +271,6 @@ func ExtractMvAudio(c string, cfg *structs.ConfigSet) (string, error) {
 	sort.Slice(audioStreams, func(i, j int) bool {
 		return audioStreams[i].Rank > audioStreams[j].Rank
 	})
-	fmt.Println("Audio: " + audioStreams[0].GroupID)
+	fmt.Fprintln(report.Out, "Audio: "+audioStreams[0].GroupID)
 	return audioStreams[0].URL, nil
 }
@@ -0,0 +1,272 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"main/internal/converter"
+	"main/internal/history"
+	"main/internal/lyrics"
+	"main/internal/metadata"
+	"main/internal/queue"
+	"main/internal/report"
+	"main/internal/structs"
+	"main/internal/tagger"
+	"main/internal/task"
+)
+
+// DryRun makes the rip functions print the paths they would write instead of
+// downloading anything.
+var DryRun bool
+
+// ripTracks runs tracks through cfg.Jobs download workers. Lyrics and
+// playlist covers of the upcoming tracks are fetched while earlier ones
+// download, and tagged tracks are handed to as many conversion workers, so
+// ffmpeg never holds up the next download. done, if set, is called once per
+// finished track, possibly from several workers at once.
+func ripTracks(tracks []*task.Track, token string, mediaUserToken string, cfg *structs.ConfigSet, counter *structs.Counter, hist *history.Store, job *queue.Manifest, dl_atmos bool, dl_aac bool, done func()) {
+	jobs := cfg.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	codec := CodecName(dl_atmos, dl_aac)
+	pre := newPrefetch(token, mediaUserToken, cfg)
+
+	// The prefetcher stays at most jobs tracks ahead of the workers.
+	started := make(chan struct{}, len(tracks))
+	go func() {
+		if DryRun {
+			return
+		}
+		running := 0
+		for i, track := range tracks {
+			for i >= running+jobs {
+				<-started
+				running++
+			}
+			if track.Type == "music-videos" {
+				continue
+			}
+			if _, ok := hist.Lookup(track.ID, codec); ok {
+				continue
+			}
+			pre.fetch(track)
+		}
+	}()
+
+	queued := make(chan *task.Track)
+	tagged := make(chan taggedTrack, len(tracks))
+	var downloads, conversions sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		downloads.Add(1)
+		go func() {
+			defer downloads.Done()
+			for track := range queued {
+				pre.begin(track)
+				started <- struct{}{}
+				state, meta := ripTrack(track, token, mediaUserToken, cfg, counter, hist, pre, dl_atmos, dl_aac)
+				pre.discard(track)
+				if state == stateTagged {
+					tagged <- taggedTrack{track, meta}
+					continue
+				}
+				job.Mark(track.ID, state)
+				if done != nil {
+					done()
+				}
+			}
+		}()
+		conversions.Add(1)
+		go func() {
+			defer conversions.Done()
+			for t := range tagged {
+				job.Mark(t.track.ID, finishTrack(t.track, t.meta, cfg, counter, hist, codec))
+				if done != nil {
+					done()
+				}
+			}
+		}()
+	}
+
+	for _, track := range tracks {
+		queued <- track
+	}
+	close(queued)
+	downloads.Wait()
+	close(tagged)
+	conversions.Wait()
+}
+
+// stateTagged is returned by ripTrack for a track that is downloaded and
+// tagged but still has to go through finishTrack.
+const stateTagged = "tagged"
+
+// taggedTrack is a track waiting for finishTrack with the tags written to it.
+type taggedTrack struct {
+	track *task.Track
+	meta  *metadata.TrackMetadata
+}
+
+// finishTrack converts a tagged track if configured and records it. The
+// converted file gets the same tags in its own format, since ffmpeg does not
+// carry the MP4 ones over.
+func finishTrack(track *task.Track, meta *metadata.TrackMetadata, cfg *structs.ConfigSet, counter *structs.Counter, hist *history.Store, codec string) string {
+	tagged := track.SavePath
+	finish := func(path string) error {
+		err := tagger.Write(path, meta)
+		if err != nil && !errors.Is(err, tagger.ErrUnsupported) {
+			fmt.Fprintln(report.Out, "\u26A0 Failed to write tags in converted file:", err)
+		}
+		return verifyFile(path, track, cfg)
+	}
+	for attempt := 0; ; attempt++ {
+		err := converter.ConvertIfNeeded(track, cfg, finish)
+		if err == nil {
+			break
+		}
+		fmt.Fprintln(report.Out, "\u26A0 Verification of converted file failed:", err)
+		if attempt < cfg.VerifyRetries {
+			fmt.Fprintln(report.Out, "Converting again...")
+			continue
+		}
+		counter.Add(&counter.Error, 1)
+		emit(report.Failed, track, codec, track.SavePath, err)
+		return queue.Failed
+	}
+	if track.SavePath != tagged {
+		emit(report.Converted, track, codec, track.SavePath, nil)
+	}
+	counter.Add(&counter.Success, 1)
+	recordHistory(hist, track, codec, track.SavePath)
+	return queue.Done
+}
+
+// prefetch holds the album data, lyrics and playlist covers fetched ahead of
+// the download workers. Each is fetched once per track; a worker asking for
+// one that is still in flight waits for it.
+type prefetch struct {
+	token          string
+	mediaUserToken string
+	cfg            *structs.ConfigSet
+
+	mu       sync.Mutex
+	entries  map[string]*fetched
+	finished map[string]bool
+}
+
+type fetched struct {
+	once  sync.Once
+	value string
+	err   error
+}
+
+func newPrefetch(token string, mediaUserToken string, cfg *structs.ConfigSet) *prefetch {
+	return &prefetch{
+		token:          token,
+		mediaUserToken: mediaUserToken,
+		cfg:            cfg,
+		entries:        make(map[string]*fetched),
+		finished:       make(map[string]bool),
+	}
+}
+
+func (p *prefetch) fetch(track *task.Track) {
+	if track.PreType == "playlists" && p.cfg.UseSongInfoForPlaylist {
+		p.AlbumData(track)
+	}
+	if p.cfg.EmbedLrc || p.cfg.SaveLrcFile {
+		p.Lyrics(track)
+	}
+	if p.cfg.EmbedCover && playlistCover(track, p.cfg) {
+		p.Cover(track)
+	}
+}
+
+// entry returns the slot for kind of track, or nil once the track is
+// finished so a late prefetch does not leave files behind.
+func (p *prefetch) entry(kind string, track *task.Track) *fetched {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.finished[track.ID] {
+		return nil
+	}
+	f, ok := p.entries[kind+"|"+track.ID]
+	if !ok {
+		f = &fetched{}
+		p.entries[kind+"|"+track.ID] = f
+	}
+	return f
+}
+
+// AlbumData loads the album of a playlist track into track.AlbumData.
+func (p *prefetch) AlbumData(track *task.Track) error {
+	f := p.entry("album", track)
+	if f == nil {
+		return nil
+	}
+	f.once.Do(func() {
+		f.err = track.GetAlbumData(p.token)
+	})
+	return f.err
+}
+
+// Lyrics returns the TTML lyrics of track.
+func (p *prefetch) Lyrics(track *task.Track) (string, error) {
+	f := p.entry("lyrics", track)
+	if f == nil {
+		return "", nil
+	}
+	f.once.Do(func() {
+		f.value, f.err = lyrics.Get(track.Storefront, track.ID, p.cfg.LrcType, p.cfg.Language, "ttml", p.token, p.mediaUserToken)
+	})
+	return f.value, f.err
+}
+
+// Cover writes the per-track cover of a playlist track and returns its path.
+func (p *prefetch) Cover(track *task.Track) (string, error) {
+	f := p.entry("cover", track)
+	if f == nil {
+		return "", nil
+	}
+	f.once.Do(func() {
+		f.value, f.err = tagger.WriteCover(track.SaveDir, track.ID, track.Resp.Attributes.Artwork.URL, p.cfg)
+	})
+	return f.value, f.err
+}
+
+// begin lets track be prefetched again after an earlier copy of it in the
+// same list was discarded.
+func (p *prefetch) begin(track *task.Track) {
+	p.mu.Lock()
+	delete(p.finished, track.ID)
+	p.mu.Unlock()
+}
+
+// discard drops what was fetched for track, removing a cover that was not
+// consumed because the track was skipped or failed.
+func (p *prefetch) discard(track *task.Track) {
+	p.mu.Lock()
+	p.finished[track.ID] = true
+	cover := p.entries["cover|"+track.ID]
+	for _, kind := range []string{"album", "lyrics", "cover"} {
+		delete(p.entries, kind+"|"+track.ID)
+	}
+	p.mu.Unlock()
+	if cover == nil {
+		return
+	}
+	// wait for a cover still being written
+	cover.once.Do(func() {})
+	if cover.err == nil && cover.value != "" {
+		os.Remove(cover.value)
+	}
+}
+
+// playlistCover reports whether track gets its own cover instead of the
+// album one.
+func playlistCover(track *task.Track, cfg *structs.ConfigSet) bool {
+	return (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && cfg.DlAlbumcoverForPlaylist
+}
@@ -0,0 +1,74 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"main/internal/lyrics"
+	"main/internal/metadata"
+	"main/internal/report"
+	"main/internal/structs"
+	"main/internal/tagger"
+	"main/internal/task"
+	"main/internal/utils"
+)
+
+// Retag rewrites the tags of the file at path, an earlier download of
+// track, with meta, and refreshes its cover and lyrics the way a download
+// would, without downloading the audio again. The file is tagged as a copy
+// that replaces it once complete.
+func Retag(path string, track *task.Track, meta *metadata.TrackMetadata, token string, mediaUserToken string, cfg *structs.ConfigSet) error {
+	if cfg.EmbedCover {
+		if err := retagCover(path, track, meta, cfg); err != nil {
+			fmt.Fprintln(report.Out, "Failed to write cover:", err)
+		}
+	}
+	if (cfg.EmbedLrc || cfg.SaveLrcFile) && len(mediaUserToken) > 50 {
+		ttml, err := lyrics.Get(track.Storefront, track.ID, cfg.LrcType, cfg.Language, "ttml", token, mediaUserToken)
+		if err != nil {
+			fmt.Fprintln(report.Out, err)
+		} else {
+			base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+			applyLyrics(ttml, filepath.Dir(path), base, meta, cfg)
+		}
+	}
+
+	if err := utils.CopyPart(path); err != nil {
+		return err
+	}
+	if err := tagger.Write(utils.PartPath(path), meta); err != nil {
+		os.Remove(utils.PartPath(path))
+		return err
+	}
+	return utils.CommitPart(path)
+}
+
+// retagCover fetches the cover of track into meta. Album tracks refresh the
+// cover file of their album folder; playlist tracks use a temporary one, as
+// they do when downloaded.
+func retagCover(path string, track *task.Track, meta *metadata.TrackMetadata, cfg *structs.ConfigSet) error {
+	dir := filepath.Dir(path)
+	name, url := track.ID, track.Resp.Attributes.Artwork.URL
+	album := track.PreType == "albums"
+	if album {
+		name, url = "cover", track.AlbumData.Attributes.Artwork.URL
+		if discFolders(track, cfg) {
+			dir = filepath.Dir(dir)
+		}
+	}
+	covPath, err := tagger.WriteCover(dir, name, url, cfg)
+	if err != nil {
+		return err
+	}
+	if !album {
+		defer os.Remove(covPath)
+	}
+	data, err := os.ReadFile(covPath)
+	if err != nil {
+		return err
+	}
+	meta.Picture = data
+	return nil
+}
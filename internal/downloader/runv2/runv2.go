@@ -20,6 +20,7 @@ import (
 
 	"github.com/cheggaaa/pb/v3"
 
+	"main/internal/report"
 	"main/internal/structs"
 )
 
@@ -135,7 +136,7 @@ func Run(adamId string, playlistUrl string, outfile string, Config structs.Confi
 			io.Copy(&buffer, barReader)
 			bar.Finish()
 			body = &buffer
-			fmt.Print("Downloaded\n")
+			fmt.Fprint(report.Out, "Downloaded\n")
 		} else {
 			body = do.Body
 		}
@@ -157,7 +158,7 @@ func Run(adamId string, playlistUrl string, outfile string, Config structs.Confi
 	if err != nil {
 		return err
 	}
-	fmt.Print("Decrypted\n")
+	fmt.Fprint(report.Out, "Decrypted\n")
 	return nil
 }
 
@@ -192,7 +193,7 @@ func downloadAndDecryptFile(conn io.ReadWriter, in io.Reader, outfile string,
 	err = sanitizeInit(init)
 	if err != nil {
 		// errors returned by sanitizeInit are non-fatal
-		fmt.Printf("Warning: unable to sanitize init completely: %s\n", err)
+		fmt.Fprintf(report.Out, "Warning: unable to sanitize init completely: %s\n", err)
 	}
 	err = init.Encode(outBuf)
 	if err != nil {
@@ -390,7 +391,7 @@ func ReadNextFragment(r io.Reader, offset uint64) (*mp4.Fragment, uint64, error)
 			frag.AddChild(box)
 			break
 		}
-		fmt.Printf("ignoring a %s box found mid-stream", boxType)
+		fmt.Fprintf(report.Out, "ignoring a %s box found mid-stream", boxType)
 	}
 	// only 1 mdat box in fragment, meaning that the box doesn't have a preceding moof box
 	if frag.Moof == nil {
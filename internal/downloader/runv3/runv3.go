@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"path/filepath"
 
+	"main/internal/report"
+
 	"github.com/go-resty/resty/v2"
 	"google.golang.org/protobuf/proto"
 
@@ -76,7 +78,7 @@ func BeforeRequest(cl *resty.Client, ctx context.Context, url string, body []byt
 		Post(url)
 
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(report.Out, err)
 	}
 
 	return resp, err
@@ -109,12 +111,12 @@ func GetWebplayback(adamId string, authtoken string, mutoken string, mvmode bool
 	}
 	jsonData, err := json.Marshal(postData)
 	if err != nil {
-		fmt.Println("Error encoding JSON:", err)
+		fmt.Fprintln(report.Out, "Error encoding JSON:", err)
 		return "", "", "", err
 	}
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(jsonData)))
 	if err != nil {
-		fmt.Println("Error creating request:", err)
+		fmt.Fprintln(report.Out, "Error creating request:", err)
 		return "", "", "", err
 	}
 	req.Header.Set("Content-Type", "application/json")
@@ -129,7 +131,7 @@ func GetWebplayback(adamId string, authtoken string, mutoken string, mvmode bool
 	// 发送请求
 	//resp, err := client.Do(req)
 	if err != nil {
-		fmt.Println("Error sending request:", err)
+		fmt.Fprintln(report.Out, "Error sending request:", err)
 		return "", "", "", err
 	}
 	defer resp.Body.Close()
@@ -137,7 +139,7 @@ func GetWebplayback(adamId string, authtoken string, mutoken string, mvmode bool
 	obj := new(Songlist)
 	err = json.NewDecoder(resp.Body).Decode(&obj)
 	if err != nil {
-		fmt.Println("json err:", err)
+		fmt.Fprintln(report.Out, "json err:", err)
 		return "", "", "", err
 	}
 	if len(obj.List) > 0 {
@@ -218,17 +220,17 @@ func extractKidBase64(b string, mvmode bool) (string, string, string, error) {
 				}
 			}
 		} else {
-			fmt.Println("No key information found")
+			fmt.Fprintln(report.Out, "No key information found")
 		}
 	} else {
-		fmt.Println("Not a media playlist")
+		fmt.Fprintln(report.Out, "Not a media playlist")
 	}
 	return kidbase64, urlBuilder.String(), uriPrefix, nil
 }
 func extsong(b string) bytes.Buffer {
 	resp, err := http.Get(b)
 	if err != nil {
-		fmt.Printf("下载文件失败: %v\n", err)
+		fmt.Fprintf(report.Out, "下载文件失败: %v\n", err)
 	}
 	defer resp.Body.Close()
 	var buffer bytes.Buffer
@@ -268,7 +270,7 @@ func Run(adamId string, trackpath string, authtoken string, mutoken string, mvmo
 	pssh, err := getPSSH("", kidBase64)
 	//fmt.Println(pssh)
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(report.Out, err)
 		return "", err
 	}
 	headers := map[string]string{
@@ -287,13 +289,13 @@ func Run(adamId string, trackpath string, authtoken string, mutoken string, mvmo
 	if serverUrl != "" {
 		keystr, keybt, err = key.GetKey(ctx, serverUrl, pssh, nil)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(report.Out, err)
 			return "", err
 		}
 	} else {
 		keystr, keybt, err = key.GetKey(ctx, "https://play.itunes.apple.com/WebObjects/MZPlay.woa/wa/acquireWebPlaybackLicense", pssh, nil)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(report.Out, err)
 			return "", err
 		}
 	}
@@ -302,28 +304,28 @@ func Run(adamId string, trackpath string, authtoken string, mutoken string, mvmo
 		return keyAndUrls, nil
 	}
 	body := extsong(fileurl)
-	fmt.Print("Downloaded\n")
+	fmt.Fprint(report.Out, "Downloaded\n")
 	//bodyReader := bytes.NewReader(body)
 	var buffer bytes.Buffer
 
 	err = DecryptMP4(&body, keybt, &buffer)
 	if err != nil {
-		fmt.Print("Decryption failed\n")
+		fmt.Fprint(report.Out, "Decryption failed\n")
 		return "", err
 	} else {
-		fmt.Print("Decrypted\n")
+		fmt.Fprint(report.Out, "Decrypted\n")
 	}
 	// create output file
 	ofh, err := os.Create(trackpath)
 	if err != nil {
-		fmt.Printf("创建文件失败: %v\n", err)
+		fmt.Fprintf(report.Out, "创建文件失败: %v\n", err)
 		return "", err
 	}
 	defer ofh.Close()
 
 	_, err = ofh.Write(buffer.Bytes())
 	if err != nil {
-		fmt.Printf("写入文件失败: %v\n", err)
+		fmt.Fprintf(report.Out, "写入文件失败: %v\n", err)
 		return "", err
 	}
 	return "", nil
@@ -344,25 +346,25 @@ func downloadSegment(url string, index int, wg *sync.WaitGroup, segmentsChan cha
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		fmt.Printf("错误(分段 %d): 创建请求失败: %v\n", index, err)
+		fmt.Fprintf(report.Out, "错误(分段 %d): 创建请求失败: %v\n", index, err)
 		return
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Printf("错误(分段 %d): 下载失败: %v\n", index, err)
+		fmt.Fprintf(report.Out, "错误(分段 %d): 下载失败: %v\n", index, err)
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("错误(分段 %d): 服务器返回状态码 %d\n", index, resp.StatusCode)
+		fmt.Fprintf(report.Out, "错误(分段 %d): 服务器返回状态码 %d\n", index, resp.StatusCode)
 		return
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		fmt.Printf("错误(分段 %d): 读取数据失败: %v\n", index, err)
+		fmt.Fprintf(report.Out, "错误(分段 %d): 读取数据失败: %v\n", index, err)
 		return
 	}
 
@@ -385,7 +387,7 @@ func fileWriter(wg *sync.WaitGroup, segmentsChan <-chan Segment, outputFile io.W
 			//fmt.Printf("写入分段 %d\n", segment.Index)
 			n, err := outputFile.Write(segment.Data)
 			if err != nil {
-				fmt.Printf("错误(分段 %d): 写入文件失败: %v\n", segment.Index, err)
+				fmt.Fprintf(report.Out, "错误(分段 %d): 写入文件失败: %v\n", segment.Index, err)
 			}
 			if bar != nil {
 				bar.Add(n)
@@ -402,7 +404,7 @@ func fileWriter(wg *sync.WaitGroup, segmentsChan <-chan Segment, outputFile io.W
 				//fmt.Printf("从缓冲区写入分段 %d\n", nextIndex)
 				n, err := outputFile.Write(data)
 				if err != nil {
-					fmt.Printf("错误(分段 %d): 从缓冲区写入文件失败: %v\n", nextIndex, err)
+					fmt.Fprintf(report.Out, "错误(分段 %d): 从缓冲区写入文件失败: %v\n", nextIndex, err)
 				}
 				if bar != nil {
 					bar.Add(n)
@@ -420,7 +422,7 @@ func fileWriter(wg *sync.WaitGroup, segmentsChan <-chan Segment, outputFile io.W
 
 	// 确保所有分段都已写入
 	if nextIndex != totalSegments {
-		fmt.Printf("警告: 写入完成，但似乎有分段丢失。期望 %d 个, 实际写入 %d 个。\n", totalSegments, nextIndex)
+		fmt.Fprintf(report.Out, "警告: 写入完成，但似乎有分段丢失。期望 %d 个, 实际写入 %d 个。\n", totalSegments, nextIndex)
 	}
 }
 
@@ -431,7 +433,7 @@ func ExtMvData(keyAndUrls string, savePath string) error {
 	urls := segments[1:]
 	tempFile, err := os.CreateTemp("", "enc_mv_data-*.mp4")
 	if err != nil {
-		fmt.Printf("创建文件失败：%v\n", err)
+		fmt.Fprintf(report.Out, "创建文件失败：%v\n", err)
 		return err
 	}
 	defer os.Remove(tempFile.Name())
@@ -482,20 +484,20 @@ func ExtMvData(keyAndUrls string, savePath string) error {
 
 	// 显式关闭文件（defer会再次调用，但重复关闭是安全的）
 	if err := tempFile.Close(); err != nil {
-		fmt.Printf("关闭临时文件失败: %v\n", err)
+		fmt.Fprintf(report.Out, "关闭临时文件失败: %v\n", err)
 		return err
 	}
-	fmt.Println("\nDownloaded.")
+	fmt.Fprintln(report.Out, "\nDownloaded.")
 
 	cmd1 := exec.Command("mp4decrypt", "--key", key, tempFile.Name(), filepath.Base(savePath))
 	cmd1.Dir = filepath.Dir(savePath) //设置mp4decrypt的工作目录以解决中文路径错误
 	outlog, err := cmd1.CombinedOutput()
 	if err != nil {
-		fmt.Printf("Decrypt failed: %v\n", err)
-		fmt.Printf("Output:\n%s\n", outlog)
+		fmt.Fprintf(report.Out, "Decrypt failed: %v\n", err)
+		fmt.Fprintf(report.Out, "Output:\n%s\n", outlog)
 		return err
 	} else {
-		fmt.Println("Decrypted.")
+		fmt.Fprintln(report.Out, "Decrypted.")
 	}
 	return nil
 }
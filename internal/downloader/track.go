@@ -1,27 +1,35 @@
 package downloader
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"main/internal/api"
 	"main/internal/converter"
 	"main/internal/downloader/runv2"
 	"main/internal/downloader/runv3"
+	"main/internal/history"
 	"main/internal/lyrics"
+	"main/internal/metadata"
+	"main/internal/naming"
+	"main/internal/queue"
+	"main/internal/report"
 	"main/internal/structs"
 	"main/internal/tagger"
 	"main/internal/task"
 	"main/internal/utils"
+	"main/internal/verify"
 )
 
-func RipSong(songId string, token string, storefront string, mediaUserToken string, cfg *structs.ConfigSet, counter *structs.Counter, okDict map[string][]int, dl_atmos bool, dl_aac bool) error {
+func RipSong(songId string, token string, storefront string, mediaUserToken string, cfg *structs.ConfigSet, counter *structs.Counter, hist *history.Store, job *queue.Manifest, dl_atmos bool, dl_aac bool) error {
 	manifest, err := api.GetSongResp(storefront, songId, cfg.Language, token)
 	if err != nil {
-		fmt.Println("Failed to get song response.")
+		fmt.Fprintln(report.Out, "Failed to get song response.")
 		return err
 	}
 
@@ -30,43 +38,63 @@ func RipSong(songId string, token string, storefront string, mediaUserToken stri
 
 	// Use album approach but only download the specific song
 	// dl_song in main implied by passing songId as urlArg_i
-	err = RipAlbum(albumId, token, storefront, mediaUserToken, songId, cfg, counter, okDict, dl_atmos, dl_aac, false, false)
+	err = RipAlbum(albumId, token, storefront, mediaUserToken, songId, cfg, counter, hist, job, dl_atmos, dl_aac, false, "")
 	if err != nil {
-		fmt.Println("Failed to rip song:", err)
+		fmt.Fprintln(report.Out, "Failed to rip song:", err)
 		return err
 	}
 
 	return nil
 }
 
-func RipTrack(track *task.Track, token string, mediaUserToken string, cfg *structs.ConfigSet, counter *structs.Counter, okDict map[string][]int, dl_atmos bool, dl_aac bool) {
+func RipTrack(track *task.Track, token string, mediaUserToken string, cfg *structs.ConfigSet, counter *structs.Counter, hist *history.Store, job *queue.Manifest, dl_atmos bool, dl_aac bool) {
+	ripTracks([]*task.Track{track}, token, mediaUserToken, cfg, counter, hist, job, dl_atmos, dl_aac, nil)
+}
+
+// ripTrack downloads and tags one track and returns its manifest state, or
+// stateTagged and the written tags when it is left to finishTrack.
+func ripTrack(track *task.Track, token string, mediaUserToken string, cfg *structs.ConfigSet, counter *structs.Counter, hist *history.Store, pre *prefetch, dl_atmos bool, dl_aac bool) (string, *metadata.TrackMetadata) {
 	var err error
-	counter.Total++
-	fmt.Printf("Track %d of %d: %s\n", track.TaskNum, track.TaskTotal, track.Type)
+	counter.Add(&counter.Total, 1)
+	fmt.Fprintf(report.Out, "Track %d of %d: %s\n", track.TaskNum, track.TaskTotal, track.Type)
+
+	codec := CodecName(dl_atmos, dl_aac)
+	emit(report.Start, track, codec, "", nil)
+	if entry, ok := hist.Lookup(track.ID, codec); ok {
+		fmt.Fprintln(report.Out, "Track already downloaded:", filepath.Base(entry.Path))
+		track.SavePath = entry.Path
+		counter.Add(&counter.Success, 1)
+		emit(report.SkippedExisting, track, codec, entry.Path, nil)
+		return queue.Done, nil
+	}
 
 	//提前获取到的播放列表下track所在的专辑信息
 	if track.PreType == "playlists" && cfg.UseSongInfoForPlaylist {
-		track.GetAlbumData(token)
+		pre.AlbumData(track)
 	}
+	meta := metadata.FromTrack(track, cfg.UseSongInfoForPlaylist, discFolders(track, cfg))
 
 	//mv dl dev
 	if track.Type == "music-videos" {
 		if len(mediaUserToken) <= 50 {
-			fmt.Println("meida-user-token is not set, skip MV dl")
-			counter.Success++
-			return
+			fmt.Fprintln(report.Out, "meida-user-token is not set, skip MV dl")
+			counter.Add(&counter.Success, 1)
+			emit(report.Unavailable, track, codec, "", fmt.Errorf("media-user-token is not set"))
+			return queue.Unavailable, nil
 		}
 		// check mp4decrypt using os/exec or similar? main.go used exec.LookPath
 		// Moving that check to caller or here? Main check was inside ripTrack.
 		// Assuming environment is checked or we check here.
-		err := MvDownloader(track.ID, track.SaveDir, token, track.Storefront, mediaUserToken, track, cfg, counter)
+		mvPath, err := MvDownloader(track.ID, track.SaveDir, token, track.Storefront, mediaUserToken, track, cfg, counter)
 		if err != nil {
-			fmt.Println("\u26A0 Failed to dl MV:", err)
-			counter.Error++
-			return
+			fmt.Fprintln(report.Out, "\u26A0 Failed to dl MV:", err)
+			counter.Add(&counter.Error, 1)
+			emit(report.Failed, track, codec, "", err)
+			return queue.Failed, nil
 		}
-		counter.Success++
-		return
+		counter.Add(&counter.Success, 1)
+		emit(report.Downloaded, track, codec, mvPath, nil)
+		return queue.Done, nil
 	}
 
 	needDlAacLc := false
@@ -75,11 +103,12 @@ func RipTrack(track *task.Track, token string, mediaUserToken string, cfg *struc
 	}
 	if track.WebM3u8 == "" && !needDlAacLc {
 		if dl_atmos {
-			fmt.Println("Unavailable")
-			counter.Unavailable++
-			return
+			fmt.Fprintln(report.Out, "Unavailable")
+			counter.Add(&counter.Unavailable, 1)
+			emit(report.Unavailable, track, codec, "", nil)
+			return queue.Unavailable, nil
 		}
-		fmt.Println("Unavailable, trying to dl aac-lc")
+		fmt.Fprintln(report.Out, "Unavailable, trying to dl aac-lc")
 		needDlAacLc = true
 	}
 	needCheck := false
@@ -106,9 +135,10 @@ func RipTrack(track *task.Track, token string, mediaUserToken string, cfg *struc
 		} else {
 			_, Quality, err = ExtractMedia(track.M3u8, true, cfg, dl_atmos, dl_aac, false) // debug_mode false for now
 			if err != nil {
-				fmt.Println("Failed to extract quality from manifest.\n", err)
-				counter.Error++
-				return
+				fmt.Fprintln(report.Out, "Failed to extract quality from manifest.\n", err)
+				counter.Add(&counter.Error, 1)
+				emit(report.Failed, track, codec, "", err)
+				return queue.Failed, nil
 			}
 		}
 	}
@@ -116,157 +146,362 @@ func RipTrack(track *task.Track, token string, mediaUserToken string, cfg *struc
 
 	stringsToJoin := []string{}
 	// IsAppleDigitalMaster field check
-	if track.Resp.Attributes.IsAppleDigitalMaster {
+	if meta.AppleDigitalMaster {
 		if cfg.AppleMasterChoice != "" {
 			stringsToJoin = append(stringsToJoin, cfg.AppleMasterChoice)
 		}
 	}
-	if track.Resp.Attributes.ContentRating == "explicit" {
+	if meta.Advisory == "explicit" {
 		if cfg.ExplicitChoice != "" {
 			stringsToJoin = append(stringsToJoin, cfg.ExplicitChoice)
 		}
 	}
-	if track.Resp.Attributes.ContentRating == "clean" {
+	if meta.Advisory == "clean" {
 		if cfg.CleanChoice != "" {
 			stringsToJoin = append(stringsToJoin, cfg.CleanChoice)
 		}
 	}
 	Tag_string := strings.Join(stringsToJoin, " ")
 
-	songName := strings.NewReplacer(
-		"{SongId}", track.ID,
-		"{SongNumer}", fmt.Sprintf("%02d", track.TaskNum),
-		"{SongName}", utils.LimitString(track.Resp.Attributes.Name, cfg.LimitMax),
-		"{DiscNumber}", fmt.Sprintf("%0d", track.Resp.Attributes.DiscNumber),
-		"{TrackNumber}", fmt.Sprintf("%0d", track.Resp.Attributes.TrackNumber),
-		"{Quality}", Quality,
-		"{Tag}", Tag_string,
-		"{Codec}", track.Codec,
-	).Replace(cfg.SongFileFormat)
-	fmt.Println(songName)
-	filename := fmt.Sprintf("%s.m4a", forbiddenNames.ReplaceAllString(songName, "_"))
-	track.SaveName = filename
-	trackPath := filepath.Join(track.SaveDir, track.SaveName)
-	lrcFilename := fmt.Sprintf("%s.%s", forbiddenNames.ReplaceAllString(songName, "_"), cfg.LrcFormat)
-
-	var convertedPath string
-	considerConverted := false
-	if cfg.ConvertAfterDownload &&
+	fields := trackFields(track, meta, cfg)
+	fields.Quality = Quality
+	fields.Codec = track.Codec
+	fields.Tag = Tag_string
+	songName, err := naming.Render(cfg.SongFileFormat, fields)
+	if err != nil {
+		fmt.Fprintln(report.Out, "Invalid song-file-format:", err)
+		counter.Add(&counter.Error, 1)
+		emit(report.Failed, track, codec, "", err)
+		return queue.Failed, nil
+	}
+	considerConverted := cfg.ConvertAfterDownload &&
 		cfg.ConvertFormat != "" &&
 		strings.ToLower(cfg.ConvertFormat) != "copy" &&
-		!cfg.ConvertKeepOriginal {
-		convertedPath = strings.TrimSuffix(trackPath, filepath.Ext(trackPath)) + "." + strings.ToLower(cfg.ConvertFormat)
-		considerConverted = true
+		!cfg.ConvertKeepOriginal
+	// a file of an earlier run with this name may be another track's, when
+	// tracks share a name; that name stays its and this track numbers on
+	isrc := "isrc:" + track.Resp.Attributes.Isrc
+	base := songName
+	var trackPath, convertedPath string
+	for {
+		songName = naming.File(track.SaveDir, base, track.ID, isrc)
+		trackPath = filepath.Join(track.SaveDir, songName+".m4a")
+		if considerConverted {
+			convertedPath = filepath.Join(track.SaveDir, songName+"."+strings.ToLower(cfg.ConvertFormat))
+		}
+		owner := fileOwner(hist, trackPath)
+		if owner == "" && considerConverted {
+			owner = fileOwner(hist, convertedPath)
+		}
+		if owner == "" || owner == track.ID || owner == isrc {
+			break
+		}
+		naming.Claim(track.SaveDir, songName, owner)
+	}
+	fmt.Fprintln(report.Out, songName)
+	track.SaveName = songName + ".m4a"
+	if DryRun {
+		fmt.Fprintln(report.Out, "Would write", trackPath)
+		return queue.Done, nil
 	}
+	// the format may put tracks in sub folders
+	os.MkdirAll(filepath.Dir(trackPath), os.ModePerm)
 	//get lrc
-	var lrc string = ""
 	if cfg.EmbedLrc || cfg.SaveLrcFile {
-		lrcStr, err := lyrics.Get(track.Storefront, track.ID, cfg.LrcType, cfg.Language, cfg.LrcFormat, token, mediaUserToken)
+		ttml, err := pre.Lyrics(track)
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(report.Out, err)
 		} else {
-			if cfg.SaveLrcFile {
-				err := tagger.WriteLyrics(track.SaveDir, lrcFilename, lrcStr)
-				if err != nil {
-					fmt.Printf("Failed to write lyrics")
-				}
-			}
-			if cfg.EmbedLrc {
-				lrc = lrcStr
-			}
+			applyLyrics(ttml, track.SaveDir, songName, meta, cfg)
 		}
 	}
 
 	existsOriginal, err := utils.FileExists(trackPath)
 	if err != nil {
-		fmt.Println("Failed to check if track exists.")
+		fmt.Fprintln(report.Out, "Failed to check if track exists.")
 	}
 	if existsOriginal {
-		fmt.Println("Track already exists locally.")
-		counter.Success++
-		okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
-		return
+		fmt.Fprintln(report.Out, "Track already exists locally.")
+		track.SavePath = trackPath
+		if missingConversion(track, cfg) {
+			// the converted file was removed, e.g. set aside by verify
+			// --repair; it is made again from the kept original
+			fmt.Fprintln(report.Out, "Converted track is missing.")
+			if cfg.EmbedCover {
+				meta.Picture = tagger.MP4Cover(trackPath)
+			}
+			return stateTagged, meta
+		}
+		counter.Add(&counter.Success, 1)
+		recordHistory(hist, track, codec, trackPath)
+		emit(report.SkippedExisting, track, codec, trackPath, nil)
+		return queue.Done, nil
 	}
 	if considerConverted {
 		existsConverted, err2 := utils.FileExists(convertedPath)
 		if err2 == nil && existsConverted {
-			fmt.Println("Converted track already exists locally.")
-			counter.Success++
-			okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
-			return
+			fmt.Fprintln(report.Out, "Converted track already exists locally.")
+			track.SavePath = convertedPath
+			counter.Add(&counter.Success, 1)
+			recordHistory(hist, track, codec, convertedPath)
+			emit(report.SkippedExisting, track, codec, convertedPath, nil)
+			return queue.Done, nil
 		}
 	}
 
-	if needDlAacLc {
-		if len(mediaUserToken) <= 50 {
-			fmt.Println("Invalid media-user-token")
-			counter.Error++
-			return
-		}
-		_, err := runv3.Run(track.ID, trackPath, token, mediaUserToken, false, "")
-		if err != nil {
-			fmt.Println("Failed to dl aac-lc:", err)
-			if err.Error() == "Unavailable" {
-				counter.Unavailable++
-				return
+	// the track is written and tagged as a part file and only gets its name
+	// once complete; the deferred remove drops it if anything fails
+	partPath := utils.PartPath(trackPath)
+	defer os.Remove(partPath)
+	// a file that does not verify is downloaded again up to verify-retries
+	// times before the track counts as failed
+	for attempt := 0; ; attempt++ {
+		if needDlAacLc {
+			if len(mediaUserToken) <= 50 {
+				fmt.Fprintln(report.Out, "Invalid media-user-token")
+				counter.Add(&counter.Error, 1)
+				emit(report.Failed, track, codec, "", fmt.Errorf("invalid media-user-token"))
+				return queue.Failed, nil
+			}
+			_, err := runv3.Run(track.ID, partPath, token, mediaUserToken, false, "")
+			if err != nil {
+				fmt.Fprintln(report.Out, "Failed to dl aac-lc:", err)
+				if err.Error() == "Unavailable" {
+					counter.Add(&counter.Unavailable, 1)
+					emit(report.Unavailable, track, codec, "", nil)
+					return queue.Unavailable, nil
+				}
+				counter.Add(&counter.Error, 1)
+				emit(report.Failed, track, codec, "", err)
+				return queue.Failed, nil
+			}
+		} else {
+			trackM3u8Url, _, err := ExtractMedia(track.M3u8, false, cfg, dl_atmos, dl_aac, false)
+			if err != nil {
+				fmt.Fprintln(report.Out, "\u26A0 Failed to extract info from manifest:", err)
+				counter.Add(&counter.Unavailable, 1)
+				emit(report.Unavailable, track, codec, "", err)
+				return queue.Unavailable, nil
+			}
+			//边下载边解密
+			err = runv2.Run(track.ID, trackM3u8Url, partPath, *cfg) // check runv2 signature to see if it accepts cfg
+			if err != nil {
+				fmt.Fprintln(report.Out, "Failed to run v2:", err)
+				counter.Add(&counter.Error, 1)
+				emit(report.Failed, track, codec, "", err)
+				return queue.Failed, nil
 			}
-			counter.Error++
-			return
 		}
-	} else {
-		trackM3u8Url, _, err := ExtractMedia(track.M3u8, false, cfg, dl_atmos, dl_aac, false)
-		if err != nil {
-			fmt.Println("\u26A0 Failed to extract info from manifest:", err)
-			counter.Unavailable++
-			return
+		err = verifyFile(partPath, track, cfg)
+		if err == nil {
+			break
 		}
-		//边下载边解密
-		err = runv2.Run(track.ID, trackM3u8Url, trackPath, *cfg) // check runv2 signature to see if it accepts cfg
-		if err != nil {
-			fmt.Println("Failed to run v2:", err)
-			counter.Error++
-			return
+		fmt.Fprintln(report.Out, "\u26A0 Verification failed:", err)
+		if attempt < cfg.VerifyRetries {
+			fmt.Fprintln(report.Out, "Downloading again...")
+			continue
 		}
+		counter.Add(&counter.Error, 1)
+		emit(report.Failed, track, codec, trackPath, err)
+		return queue.Failed, nil
 	}
+	emit(report.Downloaded, track, codec, trackPath, nil)
 
 	tags := []string{
 		"tool=",
 		"artist=AppleMusic",
 	}
 	if cfg.EmbedCover {
-		if (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && cfg.DlAlbumcoverForPlaylist {
-			track.CoverPath, err = tagger.WriteCover(track.SaveDir, track.ID, track.Resp.Attributes.Artwork.URL, cfg)
+		if playlistCover(track, cfg) {
+			track.CoverPath, err = pre.Cover(track)
 			if err != nil {
-				fmt.Println("Failed to write cover.")
+				fmt.Fprintln(report.Out, "Failed to write cover.")
 			}
 		}
 		tags = append(tags, fmt.Sprintf("cover=%s", track.CoverPath))
 	}
 	tagsString := strings.Join(tags, ":")
-	cmd := exec.Command("MP4Box", "-itags", tagsString, trackPath)
+	cmd := exec.Command("MP4Box", "-itags", tagsString, partPath)
 	if err := cmd.Run(); err != nil {
-		fmt.Printf("Embed failed: %v\n", err)
-		counter.Error++
-		return
+		fmt.Fprintf(report.Out, "Embed failed: %v\n", err)
+		counter.Add(&counter.Error, 1)
+		emit(report.Failed, track, codec, trackPath, err)
+		return queue.Failed, nil
+	}
+	// read the cover before a playlist one is removed, so converted files
+	// can be tagged with it too
+	if track.CoverPath != "" {
+		if data, err := os.ReadFile(track.CoverPath); err == nil {
+			meta.Picture = data
+		}
 	}
-	if (strings.Contains(track.PreID, "pl.") || strings.Contains(track.PreID, "ra.")) && cfg.DlAlbumcoverForPlaylist {
+	if playlistCover(track, cfg) {
 		if err := os.Remove(track.CoverPath); err != nil {
-			fmt.Printf("Error deleting file: %s\n", track.CoverPath)
-			counter.Error++
-			return
+			fmt.Fprintf(report.Out, "Error deleting file: %s\n", track.CoverPath)
+			counter.Add(&counter.Error, 1)
+			emit(report.Failed, track, codec, trackPath, err)
+			return queue.Failed, nil
 		}
 	}
 
+	err = tagger.Write(partPath, meta)
+	if err != nil {
+		fmt.Fprintln(report.Out, "\u26A0 Failed to write tags in media:", err)
+		counter.Add(&counter.Error, 1)
+		emit(report.Failed, track, codec, trackPath, err)
+		return queue.Failed, nil
+	}
+	if err := utils.CommitPart(trackPath); err != nil {
+		fmt.Fprintln(report.Out, "Failed to save track:", err)
+		counter.Add(&counter.Error, 1)
+		emit(report.Failed, track, codec, trackPath, err)
+		return queue.Failed, nil
+	}
 	track.SavePath = trackPath
-	err = tagger.WriteMP4Tags(track, lrc, cfg)
+	emit(report.Tagged, track, codec, trackPath, nil)
+
+	return stateTagged, meta
+}
+
+// missingConversion reports whether the original of track at its SavePath
+// is kept next to a converted file that does not exist.
+func missingConversion(track *task.Track, cfg *structs.ConfigSet) bool {
+	format := strings.ToLower(cfg.ConvertFormat)
+	if !cfg.ConvertAfterDownload || !cfg.ConvertKeepOriginal || format == "" || format == "copy" {
+		return false
+	}
+	ext := strings.ToLower(filepath.Ext(track.SavePath))
+	if ext == "."+format {
+		return false
+	}
+	if cfg.ConvertSkipLossyToLossless && (format == "flac" || format == "wav") && converter.IsLossySource(ext, track.Codec) {
+		return false
+	}
+	exists, err := utils.FileExists(strings.TrimSuffix(track.SavePath, ext) + "." + format)
+	return err == nil && !exists
+}
+
+// trackFields returns the naming fields of track. With disc folders the
+// song number restarts on every disc.
+func trackFields(track *task.Track, meta *metadata.TrackMetadata, cfg *structs.ConfigSet) naming.Fields {
+	fields := naming.Track(track, meta).Limit(cfg.LimitMax)
+	if discFolders(track, cfg) {
+		fields.SongNumber = meta.TrackNumber
+	}
+	return fields
+}
+
+// discFolders reports whether track goes into a folder of its disc.
+func discFolders(track *task.Track, cfg *structs.ConfigSet) bool {
+	return cfg.DiscFolderFormat != "" && track.PreType == "albums" && track.DiscTotal > 1
+}
+
+// applyLyrics writes ttml to the lyrics file dir/base in lrc-format if
+// save-lrc-file is on, and puts it into meta if embed-lrc is on.
+func applyLyrics(ttml string, dir string, base string, meta *metadata.TrackMetadata, cfg *structs.ConfigSet) {
+	if cfg.SaveLrcFile {
+		files, err := lyrics.Files(ttml, cfg.LrcFormat)
+		for _, f := range files {
+			if err == nil {
+				err = tagger.WriteLyrics(dir, lyrics.FileName(base, cfg.LrcFormat, f.Language), f.Text)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(report.Out, "Failed to write lyrics:", err)
+		}
+	}
+	if cfg.EmbedLrc {
+		text, err := lyrics.Convert(ttml, lyrics.EmbedFormat(cfg.LrcFormat))
+		if err != nil {
+			fmt.Fprintln(report.Out, "Failed to convert lyrics:", err)
+			return
+		}
+		meta.SetLyrics(text)
+	}
+}
+
+// verifyFile checks the file written for track against its catalog
+// duration, unless verify-downloads is off.
+func verifyFile(path string, track *task.Track, cfg *structs.ConfigSet) error {
+	if !cfg.VerifyDownloads {
+		return nil
+	}
+	tolerance := time.Duration(cfg.VerifyTolerance) * time.Second
+	if tolerance <= 0 {
+		tolerance = 2 * time.Second
+	}
+	expected := time.Duration(track.Resp.Attributes.DurationInMillis) * time.Millisecond
+	err := verify.File(path, expected, tolerance, FFprobe(cfg))
+	if errors.Is(err, verify.ErrNoProbe) {
+		fmt.Fprintln(report.Out, "ffprobe not found; skipping verification of", filepath.Base(strings.TrimSuffix(path, utils.PartExt)))
+		return nil
+	}
+	return err
+}
+
+// FFprobe returns ffprobe-path or, if unset, the ffprobe next to
+// ffmpeg-path.
+func FFprobe(cfg *structs.ConfigSet) string {
+	if cfg.FFprobePath != "" {
+		return cfg.FFprobePath
+	}
+	dir, file := filepath.Split(cfg.FFmpegPath)
+	if strings.Contains(file, "ffmpeg") {
+		return dir + strings.Replace(file, "ffmpeg", "ffprobe", 1)
+	}
+	return "ffprobe"
+}
+
+// emit reports a step of track to the run report.
+func emit(event string, track *task.Track, codec string, path string, err error) {
+	e := report.Event{
+		Event:    event,
+		ID:       track.ID,
+		ParentID: track.PreID,
+		ISRC:     track.Resp.Attributes.Isrc,
+		Name:     track.Name,
+		Codec:    codec,
+		Quality:  track.Quality,
+		Path:     path,
+	}
 	if err != nil {
-		fmt.Println("\u26A0 Failed to write tags in media:", err)
-		counter.Unavailable++
-		return
+		e.Error = err.Error()
 	}
+	report.Emit(e)
+}
 
-	converter.ConvertIfNeeded(track, cfg)
+// recordHistory stores a finished track so later runs skip it before any
+// network work.
+// fileOwner returns who the file at path belongs to: the track the history
+// has it for, else "isrc:" and its ISRC tag. It returns "" if there is no
+// such file or it tells neither.
+func fileOwner(hist *history.Store, path string) string {
+	if exists, _ := utils.FileExists(path); !exists {
+		return ""
+	}
+	if id, ok := hist.Owner(path); ok {
+		return id
+	}
+	if isrc := tagger.ISRC(path); isrc != "" {
+		return "isrc:" + isrc
+	}
+	return ""
+}
 
-	counter.Success++
-	okDict[track.PreID] = append(okDict[track.PreID], track.TaskNum)
+func recordHistory(hist *history.Store, track *task.Track, codec string, path string) {
+	entry := history.Entry{
+		AdamID:  track.ID,
+		ISRC:    track.Resp.Attributes.Isrc,
+		Codec:   codec,
+		Quality: track.Quality,
+		Path:    path,
+	}
+	if track.PreType == "albums" {
+		entry.AlbumID = track.PreID
+	} else if len(track.Resp.Relationships.Albums.Data) > 0 {
+		entry.AlbumID = track.Resp.Relationships.Albums.Data[0].ID
+	}
+	if err := hist.Record(entry); err != nil {
+		fmt.Fprintln(report.Out, "Failed to record download history:", err)
+	}
 }
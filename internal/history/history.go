@@ -0,0 +1,199 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"main/internal/structs"
+	"main/internal/utils"
+)
+
+// Entry is one successfully ripped track.
+type Entry struct {
+	AdamID  string    `json:"adamId"`
+	AlbumID string    `json:"albumId,omitempty"`
+	ISRC    string    `json:"isrc,omitempty"`
+	Codec   string    `json:"codec"`
+	Quality string    `json:"quality,omitempty"`
+	Path    string    `json:"path"`
+	Time    time.Time `json:"time"`
+}
+
+// Store is a JSON-lines ledger of downloaded tracks. Entries are appended as
+// tracks finish; a later line for the same track and codec wins.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// DefaultPath places the ledger next to the download folders.
+func DefaultPath(cfg *structs.ConfigSet) string {
+	if cfg.HistoryFile != "" {
+		return cfg.HistoryFile
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(cfg.AlacSaveFolder)), "history.jsonl")
+}
+
+// Open loads the ledger at path. A missing file is an empty history.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, entries: make(map[string]Entry)}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || e.AdamID == "" {
+			// skip lines torn by a crash mid-write
+			continue
+		}
+		s.entries[key(e.AdamID, e.Codec)] = e
+	}
+	return s, scanner.Err()
+}
+
+func key(adamID, codec string) string {
+	return adamID + "|" + codec
+}
+
+// Path returns the ledger file.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Lookup returns the entry for a track in codec if its file is still on disk.
+func (s *Store) Lookup(adamID, codec string) (Entry, bool) {
+	if s == nil {
+		return Entry{}, false
+	}
+	s.mu.Lock()
+	e, ok := s.entries[key(adamID, codec)]
+	s.mu.Unlock()
+	if !ok {
+		return Entry{}, false
+	}
+	exists, err := utils.FileExists(e.Path)
+	if err != nil || !exists {
+		return Entry{}, false
+	}
+	return e, true
+}
+
+// Owner returns the track the file at path was last recorded for.
+func (s *Store) Owner(path string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	path = filepath.Clean(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var owner *Entry
+	for _, e := range s.entries {
+		if filepath.Clean(e.Path) == path && (owner == nil || e.Time.After(owner.Time)) {
+			owner = &e
+		}
+	}
+	if owner == nil {
+		return "", false
+	}
+	return owner.AdamID, true
+}
+
+// Record appends e to the ledger.
+func (s *Store) Record(e Entry) error {
+	if s == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.entries[key(e.AdamID, e.Codec)] = e
+	return nil
+}
+
+// List returns all entries ordered by time.
+func (s *Store) List() []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+	return list
+}
+
+// Forget drops every entry match returns true for and compacts the ledger.
+// It returns the number of entries removed.
+func (s *Store) Forget(match func(Entry) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for k, e := range s.entries {
+		if match(e) {
+			delete(s.entries, k)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.rewrite()
+}
+
+// rewrite replaces the ledger with the current entries via a temp file so a
+// crash never leaves it half written.
+func (s *Store) rewrite() error {
+	os.MkdirAll(filepath.Dir(s.path), os.ModePerm)
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, e := range s.entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
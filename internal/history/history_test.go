@@ -0,0 +1,113 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordAndOpen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	song := filepath.Join(dir, "song.m4a")
+	os.WriteFile(song, nil, 0644)
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{
+		{AdamID: "1", Codec: "alac", Path: song, Time: time.Unix(100, 0)},
+		{AdamID: "1", Codec: "aac", Path: filepath.Join(dir, "gone.m4a"), Time: time.Unix(200, 0)},
+		{AdamID: "2", Codec: "alac", Path: filepath.Join(dir, "old.m4a"), Time: time.Unix(300, 0)},
+		{AdamID: "2", Codec: "alac", Path: song, Time: time.Unix(400, 0)},
+	}
+	for _, e := range entries {
+		if err := s.Record(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a line torn by a crash is skipped
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString(`{"adamId":"3","co`)
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		adamID, codec string
+		found         bool
+		time          int64
+	}{
+		{"1", "alac", true, 100},
+		{"1", "aac", false, 0}, // its file is gone
+		{"2", "alac", true, 400},
+		{"3", "alac", false, 0},
+	}
+	for _, tt := range tests {
+		e, ok := s.Lookup(tt.adamID, tt.codec)
+		if ok != tt.found || (ok && e.Time.Unix() != tt.time) {
+			t.Errorf("Lookup(%s, %s) = %+v, %v; want found %v at %d", tt.adamID, tt.codec, e, ok, tt.found, tt.time)
+		}
+	}
+	owners := []struct {
+		path, owner string
+		found       bool
+	}{
+		{song, "2", true}, // recorded last
+		{filepath.Join(dir, "gone.m4a"), "1", true},
+		{filepath.Join(dir, "other.m4a"), "", false},
+	}
+	for _, tt := range owners {
+		if owner, ok := s.Owner(tt.path); ok != tt.found || owner != tt.owner {
+			t.Errorf("Owner(%s) = %s, %v; want %s, %v", filepath.Base(tt.path), owner, ok, tt.owner, tt.found)
+		}
+	}
+	list := s.List()
+	if len(list) != 3 || list[0].Time.Unix() != 100 || list[2].Time.Unix() != 400 {
+		t.Errorf("List = %+v, want 3 entries by time", list)
+	}
+}
+
+func TestForget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, _ := Open(path)
+	for _, id := range []string{"1", "2", "3"} {
+		s.Record(Entry{AdamID: id, AlbumID: "a" + id, Codec: "alac", Path: id + ".m4a"})
+	}
+	tests := []struct {
+		albumID string
+		removed int
+		left    int
+	}{
+		{"a2", 1, 2},
+		{"a2", 0, 2},
+		{"a9", 0, 2},
+	}
+	for _, tt := range tests {
+		n, err := s.Forget(func(e Entry) bool { return e.AlbumID == tt.albumID })
+		if err != nil || n != tt.removed {
+			t.Errorf("Forget(%s) = %d, %v; want %d", tt.albumID, n, err, tt.removed)
+		}
+		reopened, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(reopened.List()); got != tt.left {
+			t.Errorf("after Forget(%s) the ledger has %d entries, want %d", tt.albumID, got, tt.left)
+		}
+	}
+}
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	if _, ok := s.Lookup("1", "alac"); ok {
+		t.Error("nil store found an entry")
+	}
+	if err := s.Record(Entry{AdamID: "1"}); err != nil {
+		t.Errorf("nil store Record = %v", err)
+	}
+}
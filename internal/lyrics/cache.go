@@ -0,0 +1,81 @@
+package lyrics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"main/internal/structs"
+	"main/internal/utils"
+)
+
+// defaultCacheTTL is how long cached lyrics are used when lyrics-cache-ttl
+// is not set.
+const defaultCacheTTL = 30 * 24 * time.Hour
+
+var (
+	// CacheDir holds the TTML fetched before, by storefront.
+	CacheDir string
+	// CacheTTL is how long cached TTML is used before it is fetched again;
+	// zero turns the cache off.
+	CacheTTL = defaultCacheTTL
+	// Refresh fetches lyrics again even if the cached ones are fresh
+	// (--refresh-lyrics).
+	Refresh bool
+)
+
+// configureCache applies lyrics-cache-dir and lyrics-cache-ttl. The cache
+// defaults to lyrics-cache next to the download folders.
+func configureCache(cfg *structs.ConfigSet) error {
+	CacheDir = cfg.LyricsCacheDir
+	if CacheDir == "" {
+		CacheDir = filepath.Join(filepath.Dir(filepath.Clean(cfg.AlacSaveFolder)), "lyrics-cache")
+	}
+	CacheTTL = defaultCacheTTL
+	if cfg.LyricsCacheTTL != "" {
+		ttl, err := time.ParseDuration(cfg.LyricsCacheTTL)
+		if err != nil || ttl < 0 {
+			return fmt.Errorf("lyrics-cache-ttl must be a duration such as 720h, not %q", cfg.LyricsCacheTTL)
+		}
+		CacheTTL = ttl
+	}
+	return nil
+}
+
+// cachePath is the file the TTML of a song is cached in.
+func cachePath(storefront string, songId string, lrcType string, language string) string {
+	if language == "" {
+		language = "default"
+	}
+	return filepath.Join(CacheDir, storefront, songId+"."+lrcType+"."+language+".ttml")
+}
+
+// readCache returns the TTML cached at path, if any, and whether it is
+// younger than CacheTTL.
+func readCache(path string) (ttml string, fresh bool, ok bool) {
+	if CacheTTL == 0 {
+		return "", false, false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return "", false, false
+	}
+	return string(data), time.Since(info.ModTime()) < CacheTTL, true
+}
+
+// writeCache stores ttml at path. The cache only saves requests, so
+// failures are ignored.
+func writeCache(path string, ttml string) {
+	if CacheTTL == 0 {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	utils.WriteFile(path, []byte(ttml))
+}
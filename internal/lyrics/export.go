@@ -0,0 +1,425 @@
+package lyrics
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats are the values of lrc-format: the LRC the downloader always
+// wrote, enhanced LRC with word times (A2 extension), SRT, WebVTT, ASS
+// karaoke, JSON for karaoke players and the TTML as fetched.
+var Formats = []string{"lrc", "elrc", "srt", "vtt", "ass", "json", "ttml"}
+
+// ErrNotSynced is returned for formats that need times when the lyrics have
+// none.
+var ErrNotSynced = errors.New("lyrics are not synced")
+
+// Convert turns ttml into format, with its translations as Mode asks. In
+// separate mode only the original is kept; see Files for the rest.
+func Convert(ttml string, format string) (string, error) {
+	if format == "ttml" {
+		return ttml, nil
+	}
+	l, err := Parse(ttml)
+	if err != nil {
+		return "", err
+	}
+	switch Mode {
+	case "original", "separate":
+		l = l.Original()
+	case "dual":
+		l = l.Dual()
+	}
+	return l.Format(format)
+}
+
+// Format formats l as format, which must not be ttml.
+func (l *Lyrics) Format(format string) (string, error) {
+	switch format {
+	case "lrc", "":
+		return l.Lrc(), nil
+	case "json":
+		return l.JSON()
+	}
+	if !l.Synced() {
+		return "", ErrNotSynced
+	}
+	switch format {
+	case "elrc":
+		return l.EnhancedLrc(), nil
+	case "srt":
+		return l.Srt(), nil
+	case "vtt":
+		return l.WebVTT(), nil
+	case "ass":
+		return l.Ass(), nil
+	}
+	return "", fmt.Errorf("unknown lyrics format %q", format)
+}
+
+// File is a lyrics file of a track. Language is empty for the main file
+// and set for the translations written in separate mode.
+type File struct {
+	Language string
+	Text     string
+}
+
+// Files turns ttml into the lyrics files of a track: the one of Convert,
+// and in separate mode one for each translation and transliteration,
+// named after its xml:lang. Translations of ttml are kept in it as fetched.
+func Files(ttml string, format string) ([]File, error) {
+	text, err := Convert(ttml, format)
+	if err != nil {
+		return nil, err
+	}
+	files := []File{{Text: text}}
+	if Mode != "separate" || format == "ttml" {
+		return files, nil
+	}
+	l, err := Parse(ttml)
+	if err != nil {
+		return nil, err
+	}
+	for _, list := range [][]Translation{l.Transliterations, l.Translations} {
+		for i := range list {
+			if list[i].Language == "" {
+				continue
+			}
+			text, err := l.In(&list[i]).Format(format)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, File{Language: list[i].Language, Text: text})
+		}
+	}
+	return files, nil
+}
+
+// FileName is the name of the lyrics file of the track named base in
+// format and language, such as song.lrc or song.en.lrc.
+func FileName(base string, format string, language string) string {
+	if language != "" {
+		base += "." + language
+	}
+	return base + "." + Ext(format)
+}
+
+// Ext is the file extension of format, without the dot.
+func Ext(format string) string {
+	if format == "elrc" || format == "" {
+		return "lrc"
+	}
+	return format
+}
+
+// EmbedFormat is the format lyrics are embedded in when format is chosen
+// for the lyrics file: subtitles and JSON are embedded as LRC.
+func EmbedFormat(format string) string {
+	switch format {
+	case "srt", "vtt", "ass", "json":
+		return "lrc"
+	}
+	return format
+}
+
+// TtmlToLrc converts ttml to LRC, see Lyrics.Lrc.
+func TtmlToLrc(ttml string) (string, error) {
+	return Convert(ttml, "lrc")
+}
+
+// Lrc formats l as the LRC the downloader has always written: line times,
+// or for word-timed lyrics word times in angle brackets. The first
+// translation goes on a line of its own before each line, and lines with
+// CJK text are replaced by their transliteration if there is one. Lyrics
+// without times become plain lines. In duets each line starts with its
+// singer, and background vocals follow the line in parentheses.
+func (l *Lyrics) Lrc() string {
+	var lines []string
+	speakers := l.speakers()
+	if !l.Synced() {
+		for _, line := range l.Lines {
+			lines = append(lines, speaker(speakers, line.Agent)+line.Sung())
+		}
+		return strings.Join(lines, "\n")
+	}
+	translation := l.Translation("")
+	transliteration := l.Transliteration("")
+	for _, line := range l.Lines {
+		prefix := speaker(speakers, line.Agent)
+		begin := "[" + lrcTime(line.Begin) + "]"
+		text := begin + prefix + line.Sung()
+		translit := ""
+		if t := transliteration.Text(line.Key); t != "" {
+			translit = begin + prefix + t
+		}
+		if l.Timing == "Word" && len(line.Words) > 0 {
+			text = begin + prefix + wordLrc(line.Words, true) + "<" + lrcTime(line.Words[len(line.Words)-1].End) + ">"
+			if bg := line.Background; len(bg) > 0 {
+				text += " (" + wordLrc(bg, true) + "<" + lrcTime(bg[len(bg)-1].End) + ">)"
+			}
+			translit = ""
+			if transliteration != nil {
+				if words := transliteration.Lines[line.Key].Words; len(words) > 0 {
+					// transliterations carry their own word times
+					begin = "[" + lrcTime(words[0].Begin) + "]"
+					translit = begin + prefix + wordLrc(words, false)
+				}
+			}
+		}
+		if t := translation.Text(line.Key); t != "" {
+			lines = append(lines, begin+prefix+t)
+		}
+		if translit != "" && containsCJK(line.Text) {
+			lines = append(lines, translit)
+		} else {
+			lines = append(lines, text)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wordLrc formats words with their times in angle brackets. With spaces the
+// spaces of the line are kept; otherwise words are joined by spaces.
+func wordLrc(words []Word, spaces bool) string {
+	var b strings.Builder
+	for i, w := range words {
+		if !spaces && i > 0 {
+			b.WriteString(" ")
+		}
+		b.WriteString("<" + lrcTime(w.Begin) + ">" + w.Text)
+		if spaces && w.Space {
+			b.WriteString(" ")
+		}
+	}
+	return b.String()
+}
+
+// EnhancedLrc formats l as LRC with the A2 extension: each word starts with
+// its time in angle brackets and the line ends with the time of its end.
+// Background vocals follow the words of the line in parentheses.
+func (l *Lyrics) EnhancedLrc() string {
+	var b strings.Builder
+	speakers := l.speakers()
+	for _, line := range l.Lines {
+		prefix := speaker(speakers, line.Agent)
+		b.WriteString("[" + lrcTime(line.Begin) + "]")
+		if len(line.Words) == 0 {
+			b.WriteString(prefix + line.Sung() + "\n")
+			continue
+		}
+		// the words bring their own spaces
+		b.WriteString(strings.TrimSuffix(prefix, " "))
+		end := line.End
+		for _, w := range append(append([]Word{}, line.Words...), parenthesized(line.Background)...) {
+			fmt.Fprintf(&b, " <%s> %s", lrcTime(w.Begin), w.Text)
+			end = max(end, w.End)
+		}
+		fmt.Fprintf(&b, " <%s>\n", lrcTime(end))
+	}
+	return b.String()
+}
+
+// Srt formats l as SubRip subtitles, one cue per line.
+func (l *Lyrics) Srt() string {
+	var b strings.Builder
+	speakers := l.speakers()
+	for i, line := range l.Lines {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1,
+			clockTime(line.Begin, ","), clockTime(cueEnd(l, i), ","), speaker(speakers, line.Agent)+line.Sung())
+	}
+	return b.String()
+}
+
+// WebVTT formats l as WebVTT subtitles. Word-timed lines get timestamp
+// tags, which players show as karaoke, and duets get voice tags.
+func (l *Lyrics) WebVTT() string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n\n")
+	speakers := l.speakers()
+	for i, line := range l.Lines {
+		fmt.Fprintf(&b, "%s --> %s\n", clockTime(line.Begin, "."), clockTime(cueEnd(l, i), "."))
+		if name := speakers[line.Agent]; name != "" {
+			b.WriteString("<v " + vttEscape(name) + ">")
+		}
+		if len(line.Words) == 0 {
+			b.WriteString(vttEscape(line.Sung()) + "\n\n")
+			continue
+		}
+		for j, w := range line.Words {
+			if j > 0 {
+				fmt.Fprintf(&b, "<%s>", clockTime(w.Begin, "."))
+			}
+			b.WriteString(vttEscape(w.Text))
+			if w.Space && j < len(line.Words)-1 {
+				b.WriteString(" ")
+			}
+		}
+		// timestamps must not go back, so background vocals are not timed
+		if len(line.Background) > 0 {
+			b.WriteString(" (" + vttEscape(wordsText(line.Background)) + ")")
+		}
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
+
+// Ass formats l as Advanced SubStation Alpha subtitles. Word-timed lines
+// get \k karaoke tags. In duets the lines of the second singer and of the
+// group get styles of their own, and background vocals are shown smaller
+// above the line.
+func (l *Lyrics) Ass() string {
+	var b strings.Builder
+	b.WriteString("[Script Info]\nScriptType: v4.00+\nPlayResX: 1920\nPlayResY: 1080\nWrapStyle: 0\n\n")
+	b.WriteString("[V4+ Styles]\n")
+	b.WriteString("Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding\n")
+	b.WriteString("Style: Default,Arial,64,&H00FFFFFF,&H00808080,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,0,2,60,60,60,1\n")
+	b.WriteString("Style: Duet,Arial,64,&H00FFC080,&H00808080,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,0,2,60,60,60,1\n")
+	b.WriteString("Style: Group,Arial,64,&H0000FFFF,&H00808080,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,0,2,60,60,60,1\n")
+	b.WriteString("Style: Background,Arial,48,&H00C0C0C0,&H00606060,&H00000000,&H80000000,0,1,0,0,100,100,0,0,1,2,0,2,60,60,140,1\n\n")
+	b.WriteString("[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+	speakers := l.speakers()
+	for i, line := range l.Lines {
+		style, name := l.assStyle(line.Agent), assEscape(speakers[line.Agent])
+		switch {
+		case len(line.Words) > 0:
+			fmt.Fprintf(&b, "Dialogue: 0,%s,%s,%s,%s,0,0,0,,%s\n", assTime(line.Begin), assTime(cueEnd(l, i)), style, name,
+				assKaraoke(line.Begin, line.Words))
+		case line.Text != "" || len(line.Background) == 0:
+			fmt.Fprintf(&b, "Dialogue: 0,%s,%s,%s,%s,0,0,0,,%s\n", assTime(line.Begin), assTime(cueEnd(l, i)), style, name,
+				assEscape(line.Text))
+		}
+		if bg := line.Background; len(bg) > 0 {
+			fmt.Fprintf(&b, "Dialogue: 1,%s,%s,Background,%s,0,0,0,,%s\n", assTime(bg[0].Begin), assTime(max(bg[len(bg)-1].End, cueEnd(l, i))), name,
+				assKaraoke(bg[0].Begin, parenthesized(bg)))
+		}
+	}
+	return b.String()
+}
+
+// assKaraoke formats words sung from at with \k tags.
+func assKaraoke(at time.Duration, words []Word) string {
+	var b strings.Builder
+	for _, w := range words {
+		// a gap before the word is sung as an empty syllable
+		if gap := w.Begin - at; gap >= 10*time.Millisecond {
+			fmt.Fprintf(&b, "{\\k%d}", gap/(10*time.Millisecond))
+		}
+		fmt.Fprintf(&b, "{\\k%d}%s", (w.End-w.Begin)/(10*time.Millisecond), assEscape(w.Text))
+		if w.Space {
+			b.WriteString(" ")
+		}
+		at = w.End
+	}
+	return b.String()
+}
+
+// assStyle is the style of the lines of agent: Default for the first
+// singer, Duet for the others and Group for groups.
+func (l *Lyrics) assStyle(agent string) string {
+	persons := 0
+	for _, a := range l.Agents {
+		if a.Type == "group" {
+			if a.ID == agent {
+				return "Group"
+			}
+			continue
+		}
+		if a.ID == agent && persons > 0 {
+			return "Duet"
+		}
+		persons++
+	}
+	return "Default"
+}
+
+// speakers returns the label of each agent lines of l are attributed to:
+// its name, All for a group or else its ID such as v2. It returns nil if a
+// single agent sings all lines.
+func (l *Lyrics) speakers() map[string]string {
+	labels := make(map[string]string)
+	for _, line := range l.Lines {
+		if line.Agent != "" {
+			labels[line.Agent] = line.Agent
+		}
+	}
+	if len(labels) < 2 {
+		return nil
+	}
+	for _, a := range l.Agents {
+		if _, ok := labels[a.ID]; !ok {
+			continue
+		}
+		if a.Name != "" {
+			labels[a.ID] = a.Name
+		} else if a.Type == "group" {
+			labels[a.ID] = "All"
+		}
+	}
+	return labels
+}
+
+// speaker is the prefix of the lines of agent in LRC and SRT, empty
+// unless speakers has a label for it.
+func speaker(speakers map[string]string, agent string) string {
+	if name := speakers[agent]; name != "" {
+		return name + ": "
+	}
+	return ""
+}
+
+// parenthesized returns a copy of words with a parenthesis before the
+// first and after the last.
+func parenthesized(words []Word) []Word {
+	if len(words) == 0 {
+		return nil
+	}
+	words = append([]Word{}, words...)
+	words[0].Text = "(" + words[0].Text
+	words[len(words)-1].Text += ")"
+	return words
+}
+
+// cueEnd is the end of line i as a subtitle: its own end, else the start of
+// the next line, else a few seconds after it starts.
+func cueEnd(l *Lyrics, i int) time.Duration {
+	line := l.Lines[i]
+	if line.End > line.Begin {
+		return line.End
+	}
+	if i+1 < len(l.Lines) && l.Lines[i+1].Begin > line.Begin {
+		return l.Lines[i+1].Begin
+	}
+	return line.Begin + 5*time.Second
+}
+
+// lrcTime formats d as mm:ss.xx.
+func lrcTime(d time.Duration) string {
+	cs := int(d / (10 * time.Millisecond))
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, cs/100%60, cs%100)
+}
+
+// clockTime formats d as hh:mm:ss followed by sep and milliseconds.
+func clockTime(d time.Duration, sep string) string {
+	ms := int(d / time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// assTime formats d as h:mm:ss.cc.
+func assTime(d time.Duration) string {
+	cs := int(d / (10 * time.Millisecond))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", cs/360000, cs/6000%60, cs/100%60, cs%100)
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func vttEscape(s string) string {
+	return vttEscaper.Replace(s)
+}
+
+var assEscaper = strings.NewReplacer("{", "(", "}", ")", "\n", "\\N")
+
+func assEscape(s string) string {
+	return assEscaper.Replace(s)
+}
@@ -0,0 +1,196 @@
+package lyrics
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden compares got with the golden file name in testdata, or rewrites it
+// with -update.
+func golden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the golden file:\n--- got\n%s\n--- want\n%s", name, got, want)
+	}
+}
+
+func readTTML(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name+".ttml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func setMode(t *testing.T, mode string) {
+	t.Helper()
+	old := Mode
+	Mode = mode
+	t.Cleanup(func() { Mode = old })
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		doc    string
+		mode   string
+		synced bool
+	}{
+		{"line", "inline", true},
+		{"word", "inline", true},
+		{"untimed", "inline", false},
+		{"translated", "inline", true},
+		{"translated", "original", true},
+		{"translated", "dual", true},
+	}
+	for _, tt := range tests {
+		setMode(t, tt.mode)
+		ttml := readTTML(t, tt.doc)
+		for _, format := range []string{"lrc", "elrc", "srt", "vtt", "ass", "json"} {
+			name := tt.doc + "." + tt.mode + "." + format
+			got, err := Convert(ttml, format)
+			if !tt.synced && format != "lrc" && format != "json" {
+				if !errors.Is(err, ErrNotSynced) {
+					t.Errorf("%s: Convert = %v, want ErrNotSynced", name, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			golden(t, name, got)
+		}
+	}
+}
+
+func TestFilesSeparate(t *testing.T) {
+	setMode(t, "separate")
+	ttml := readTTML(t, "translated")
+	for _, format := range []string{"lrc", "srt"} {
+		files, err := Files(ttml, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var languages []string
+		for _, f := range files {
+			languages = append(languages, f.Language)
+			golden(t, FileName("translated.separate", format, f.Language), f.Text)
+		}
+		if len(languages) != 3 || languages[0] != "" || languages[1] != "ja-Latn" || languages[2] != "en" {
+			t.Errorf("Files(%s) languages = %q, want the original, ja-Latn and en", format, languages)
+		}
+	}
+
+	// ttml is kept whole with its translations
+	files, err := Files(ttml, "ttml")
+	if err != nil || len(files) != 1 || files[0].Text != ttml {
+		t.Errorf("Files(ttml) = %d files, %v, want the document as is", len(files), err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	l, err := Parse(readTTML(t, "word"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Timing != "Word" || l.Language != "en" || len(l.Agents) != 3 || len(l.Lines) != 4 {
+		t.Fatalf("Parse = timing %s, language %s, %d agents, %d lines", l.Timing, l.Language, len(l.Agents), len(l.Lines))
+	}
+	tests := []struct {
+		line       int
+		text, sung string
+		part       string
+		background int
+	}{
+		{0, "Hello world", "Hello world", "Verse", 0},
+		{1, "How are you", "How are you (ooh yeah)", "Verse", 2},
+		{2, "", "(echo)", "Verse", 1},
+		{3, "All & one", "All & one", "Chorus", 0},
+	}
+	for _, tt := range tests {
+		line := l.Lines[tt.line]
+		if line.Text != tt.text || line.Sung() != tt.sung || line.Part != tt.part || len(line.Background) != tt.background {
+			t.Errorf("line %d = %q, sung %q, part %q, %d background words; want %q, %q, %q, %d",
+				tt.line, line.Text, line.Sung(), line.Part, len(line.Background), tt.text, tt.sung, tt.part, tt.background)
+		}
+	}
+	if len(l.Parts) != 2 || l.Parts[1].Begin != 10*time.Second || l.Parts[1].End != 13*time.Second {
+		t.Errorf("Parts = %+v", l.Parts)
+	}
+
+	l, err = Parse(readTTML(t, "line"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := l.Lines[1].End; got != 20*time.Second {
+		t.Errorf("line without an end ends at %v, want the start of the next line", got)
+	}
+
+	for _, doc := range []string{"", "<tt", "<html/>", `<tt itunes:timing="Line"><body><div><p>no begin</p></div></body></tt>`} {
+		if _, err := Parse(doc); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", doc)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"3.456", 3456 * time.Millisecond, true},
+		{"3.456s", 3456 * time.Millisecond, true},
+		{"12", 12 * time.Second, true},
+		{" 7.5 ", 7500 * time.Millisecond, true},
+		{"02:03.456", 2*time.Minute + 3456*time.Millisecond, true},
+		{"1:02:03.456", time.Hour + 2*time.Minute + 3456*time.Millisecond, true},
+		{"0:00:00.000", 0, true},
+		{"0.0005", time.Millisecond, true},
+		{"59.9999", time.Minute, true},
+		{"", 0, false},
+		{"s", 0, false},
+		{":30", 0, false},
+		{"1:2:3:4", 0, false},
+		{"a:30", 0, false},
+		{"1:xx", 0, false},
+		{"1.5:30", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseTime(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseTime(%q) = %v, %v; want %v, ok %v", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTimeFormats(t *testing.T) {
+	d := time.Hour + 2*time.Minute + 3*time.Second + 456*time.Millisecond
+	if got := lrcTime(d); got != "62:03.45" {
+		t.Errorf("lrcTime = %q", got)
+	}
+	if got := clockTime(d, ","); got != "01:02:03,456" {
+		t.Errorf("clockTime = %q", got)
+	}
+	if got := assTime(d); got != "1:02:03.45" {
+		t.Errorf("assTime = %q", got)
+	}
+}
@@ -0,0 +1,115 @@
+package lyrics
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// jsonLyrics is the JSON export of Lyrics. Times are in milliseconds.
+type jsonLyrics struct {
+	Language         string            `json:"language,omitempty"`
+	Timing           string            `json:"timing"`
+	Agents           []jsonAgent       `json:"agents,omitempty"`
+	Songwriters      []string          `json:"songwriters,omitempty"`
+	Parts            []jsonPart        `json:"parts,omitempty"`
+	Lines            []jsonLine        `json:"lines"`
+	Translations     []jsonTranslation `json:"translations,omitempty"`
+	Transliterations []jsonTranslation `json:"transliterations,omitempty"`
+}
+
+type jsonAgent struct {
+	ID   string `json:"id"`
+	Type string `json:"type,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type jsonPart struct {
+	Name  string `json:"name"`
+	Begin int64  `json:"begin"`
+	End   int64  `json:"end"`
+}
+
+type jsonLine struct {
+	Key        string     `json:"key,omitempty"`
+	Agent      string     `json:"agent,omitempty"`
+	Part       string     `json:"part,omitempty"`
+	Begin      int64      `json:"begin"`
+	End        int64      `json:"end"`
+	Text       string     `json:"text"`
+	Words      []jsonWord `json:"words,omitempty"`
+	Background []jsonWord `json:"background,omitempty"`
+}
+
+type jsonWord struct {
+	Begin int64  `json:"begin"`
+	End   int64  `json:"end"`
+	Text  string `json:"text"`
+	Space bool   `json:"space,omitempty"`
+}
+
+type jsonTranslation struct {
+	Language string              `json:"language"`
+	Type     string              `json:"type,omitempty"`
+	Lines    map[string]jsonText `json:"lines"`
+}
+
+type jsonText struct {
+	Text  string     `json:"text"`
+	Words []jsonWord `json:"words,omitempty"`
+}
+
+// JSON formats l as JSON for karaoke players: the lines with their singers,
+// word times and background vocals, the parts of the song and the
+// translations by line key. Times are in milliseconds.
+func (l *Lyrics) JSON() (string, error) {
+	out := jsonLyrics{Language: l.Language, Timing: l.Timing, Songwriters: l.Songwriters, Lines: []jsonLine{}}
+	for _, a := range l.Agents {
+		out.Agents = append(out.Agents, jsonAgent(a))
+	}
+	for _, p := range l.Parts {
+		out.Parts = append(out.Parts, jsonPart{Name: p.Name, Begin: ms(p.Begin), End: ms(p.End)})
+	}
+	for _, line := range l.Lines {
+		out.Lines = append(out.Lines, jsonLine{
+			Key:        line.Key,
+			Agent:      line.Agent,
+			Part:       line.Part,
+			Begin:      ms(line.Begin),
+			End:        ms(line.End),
+			Text:       line.Text,
+			Words:      jsonWords(line.Words),
+			Background: jsonWords(line.Background),
+		})
+	}
+	out.Translations = jsonTranslations(l.Translations)
+	out.Transliterations = jsonTranslations(l.Transliterations)
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+func jsonWords(words []Word) []jsonWord {
+	var out []jsonWord
+	for _, w := range words {
+		out = append(out, jsonWord{Begin: ms(w.Begin), End: ms(w.End), Text: w.Text, Space: w.Space})
+	}
+	return out
+}
+
+func jsonTranslations(list []Translation) []jsonTranslation {
+	var out []jsonTranslation
+	for _, t := range list {
+		lines := make(map[string]jsonText, len(t.Lines))
+		for key, line := range t.Lines {
+			lines[key] = jsonText{Text: line.Text, Words: jsonWords(line.Words)}
+		}
+		out = append(out, jsonTranslation{Language: t.Language, Type: t.Type, Lines: lines})
+	}
+	return out
+}
+
+func ms(d time.Duration) int64 {
+	return d.Milliseconds()
+}
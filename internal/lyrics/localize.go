@@ -0,0 +1,133 @@
+package lyrics
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"main/internal/structs"
+
+	"github.com/beevik/etree"
+)
+
+// Modes are the values of lrc-translations: translations inline as the
+// downloader always wrote them, the original only, each translation on a
+// line of its own after the original, or a separate file per language.
+var Modes = []string{"inline", "original", "dual", "separate"}
+
+var (
+	// Mode is how translations are written, one of Modes.
+	Mode = "inline"
+	// Languages are the extra languages whose translations are fetched
+	// along with the lyrics, from lrc-languages.
+	Languages []string
+)
+
+// Configure applies the lyrics options of cfg.
+func Configure(cfg *structs.ConfigSet) error {
+	Mode = "inline"
+	if cfg.LrcTranslations != "" {
+		if !slices.Contains(Modes, cfg.LrcTranslations) {
+			return fmt.Errorf("lrc-translations must be one of %s, not %q", strings.Join(Modes, ", "), cfg.LrcTranslations)
+		}
+		Mode = cfg.LrcTranslations
+	}
+	Languages = cfg.LrcLanguages
+	return configureCache(cfg)
+}
+
+// localize fetches the translations of song into each of Languages and
+// adds them to ttml. Languages that fail are left out.
+func localize(ttml string, songId string, storefront string, token string, userToken string, lrcType string, language string) string {
+	var others []string
+	for _, l := range Languages {
+		if strings.EqualFold(l, language) {
+			continue
+		}
+		if other, err := getSongLyrics(songId, storefront, token, userToken, lrcType, l); err == nil {
+			others = append(others, other)
+		}
+	}
+	merged, err := mergeLocalizations(ttml, others...)
+	if err != nil {
+		return ttml
+	}
+	return merged
+}
+
+// mergeLocalizations adds to ttml the translations and transliterations of
+// others in languages it does not have yet.
+func mergeLocalizations(ttml string, others ...string) (string, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(ttml); err != nil {
+		return "", err
+	}
+	tt := doc.FindElement("tt")
+	if tt == nil {
+		return ttml, nil
+	}
+	changed := false
+	for _, other := range others {
+		if other == "" {
+			continue
+		}
+		src := etree.NewDocument()
+		if err := src.ReadFromString(other); err != nil {
+			continue
+		}
+		from := src.FindElement("tt/head/metadata/iTunesMetadata")
+		if from == nil {
+			continue
+		}
+		for _, kind := range []string{"translations", "transliterations"} {
+			for _, t := range from.FindElements(kind + "/*") {
+				to := child(iTunesMetadata(tt), kind)
+				if hasLanguage(to, t.SelectAttrValue("xml:lang", "")) {
+					continue
+				}
+				to.AddChild(t.Copy())
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return ttml, nil
+	}
+	return doc.WriteToString()
+}
+
+// iTunesMetadata returns the iTunesMetadata element of tt, creating it and
+// its parents if needed.
+func iTunesMetadata(tt *etree.Element) *etree.Element {
+	metadata := child(child(tt, "head"), "metadata")
+	itunes := metadata.SelectElement("iTunesMetadata")
+	if itunes == nil {
+		itunes = metadata.CreateElement("iTunesMetadata")
+		itunes.CreateAttr("xmlns", "http://music.apple.com/lyric-ttml-internal")
+	}
+	return itunes
+}
+
+// child returns the first child element tag of e, creating it if needed.
+// A created head goes first, as TTML requires.
+func child(e *etree.Element, tag string) *etree.Element {
+	if c := e.SelectElement(tag); c != nil {
+		return c
+	}
+	c := etree.NewElement(tag)
+	if tag == "head" {
+		e.InsertChildAt(0, c)
+	} else {
+		e.AddChild(c)
+	}
+	return c
+}
+
+func hasLanguage(e *etree.Element, language string) bool {
+	for _, c := range e.ChildElements() {
+		if strings.EqualFold(c.SelectAttrValue("xml:lang", ""), language) {
+			return true
+		}
+	}
+	return false
+}
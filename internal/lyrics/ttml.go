@@ -0,0 +1,436 @@
+package lyrics
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// Lyrics is a parsed TTML lyrics document.
+type Lyrics struct {
+	Timing           string // Line, Word or None, from itunes:timing
+	Language         string // xml:lang of the lyrics
+	Agents           []Agent
+	Songwriters      []string
+	Parts            []Part
+	Lines            []Line
+	Translations     []Translation
+	Transliterations []Translation
+}
+
+// Agent is a singer lines can be attributed to, such as v1 and v2 in duets
+// or v1000 for a group.
+type Agent struct {
+	ID   string
+	Type string // person, group or other
+	Name string
+}
+
+// Part is a section of the song, from the itunes:songPart of a div.
+type Part struct {
+	Name  string // Verse, Chorus, ...; may be empty
+	Begin time.Duration
+	End   time.Duration
+}
+
+// Line is one lyrics line. Words are only set for word-timed lyrics;
+// Background holds the background vocals sung along with the line.
+type Line struct {
+	Key        string // itunes:key, which translations refer to
+	Agent      string
+	Part       string
+	Begin      time.Duration
+	End        time.Duration
+	Text       string
+	Words      []Word
+	Background []Word
+}
+
+// Sung returns the text of line with its background vocals in parentheses.
+func (line Line) Sung() string {
+	if len(line.Background) == 0 {
+		return line.Text
+	}
+	background := "(" + wordsText(line.Background) + ")"
+	if line.Text == "" {
+		return background
+	}
+	return line.Text + " " + background
+}
+
+// Word is one timed word or syllable. Space tells whether a space follows
+// it in the line.
+type Word struct {
+	Begin time.Duration
+	End   time.Duration
+	Text  string
+	Space bool
+}
+
+// Translation is a translation or transliteration of the lines, keyed by
+// the Key of the line.
+type Translation struct {
+	Language string
+	Type     string // replacement or subtitle for translations
+	Lines    map[string]TextLine
+}
+
+// TextLine is the text of a line in a translation. Transliterations of
+// word-timed lyrics have their own word times.
+type TextLine struct {
+	Text  string
+	Words []Word
+}
+
+// Synced reports whether the lines of l have times.
+func (l *Lyrics) Synced() bool {
+	return l.Timing != "None" && len(l.Lines) > 0
+}
+
+// Translation returns the translation into language, or the first one if
+// language is empty. It returns nil if there is none.
+func (l *Lyrics) Translation(language string) *Translation {
+	return findTranslation(l.Translations, language)
+}
+
+// Transliteration returns the transliteration into language, or the first
+// one if language is empty. It returns nil if there is none.
+func (l *Lyrics) Transliteration(language string) *Translation {
+	return findTranslation(l.Transliterations, language)
+}
+
+func findTranslation(list []Translation, language string) *Translation {
+	for i := range list {
+		if language == "" || strings.EqualFold(list[i].Language, language) {
+			return &list[i]
+		}
+	}
+	return nil
+}
+
+// Text returns the text of the line with key, or "" if t is nil or has no
+// such line.
+func (t *Translation) Text(key string) string {
+	if t == nil {
+		return ""
+	}
+	return t.Lines[key].Text
+}
+
+// Original returns a copy of l without its translations and
+// transliterations.
+func (l *Lyrics) Original() *Lyrics {
+	o := *l
+	o.Translations, o.Transliterations = nil, nil
+	return &o
+}
+
+// Dual returns the original of l with the transliteration and then each
+// translation of a line on lines of their own after it, at its times.
+func (l *Lyrics) Dual() *Lyrics {
+	o := l.Original()
+	o.Lines = nil
+	others := append(append([]Translation{}, l.Transliterations...), l.Translations...)
+	for _, line := range l.Lines {
+		o.Lines = append(o.Lines, line)
+		for i := range others {
+			if text := others[i].Text(line.Key); text != "" {
+				o.Lines = append(o.Lines, Line{Key: line.Key, Agent: line.Agent, Part: line.Part,
+					Begin: line.Begin, End: line.End, Text: text})
+			}
+		}
+	}
+	return o
+}
+
+// In returns l in the language of the translation or transliteration t:
+// the text of each line is replaced by that in t, with the word times of t
+// if it has any. Lines t has no text for, such as those already in Latin
+// script in a transliteration, keep their original text.
+func (l *Lyrics) In(t *Translation) *Lyrics {
+	o := l.Original()
+	o.Language = t.Language
+	o.Lines = make([]Line, len(l.Lines))
+	for i, line := range l.Lines {
+		text, ok := t.Lines[line.Key]
+		if !ok || text.Text == "" {
+			o.Lines[i] = line
+			continue
+		}
+		line.Text, line.Words, line.Background = text.Text, nil, nil
+		if len(text.Words) > 0 {
+			line.Words = append([]Word{}, text.Words...)
+			// transliterated syllables come without spaces
+			for j := range line.Words[:len(line.Words)-1] {
+				line.Words[j].Space = true
+			}
+		}
+		o.Lines[i] = line
+	}
+	return o
+}
+
+// Parse reads a TTML lyrics document.
+func Parse(ttml string) (*Lyrics, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(ttml); err != nil {
+		return nil, err
+	}
+	tt := doc.FindElement("tt")
+	if tt == nil {
+		return nil, errors.New("not a TTML document")
+	}
+	l := &Lyrics{
+		Timing:   tt.SelectAttrValue("itunes:timing", "Line"),
+		Language: tt.SelectAttrValue("xml:lang", ""),
+	}
+	if metadata := tt.FindElement("head/metadata"); metadata != nil {
+		if err := l.parseMetadata(metadata); err != nil {
+			return nil, err
+		}
+	}
+	body := tt.FindElement("body")
+	if body == nil {
+		return l, nil
+	}
+	for _, div := range body.FindElements(".//div") {
+		part := Part{Name: div.SelectAttrValue("itunes:songPart", "")}
+		first := true
+		for _, p := range div.SelectElements("p") {
+			line, err := parseLine(p, l.Timing)
+			if err != nil {
+				return nil, err
+			}
+			// unsynced lyrics have no use for empty lines
+			if l.Timing == "None" && line.Text == "" {
+				continue
+			}
+			line.Part = part.Name
+			if first || line.Begin < part.Begin {
+				part.Begin = line.Begin
+			}
+			if line.End > part.End {
+				part.End = line.End
+			}
+			first = false
+			l.Lines = append(l.Lines, line)
+		}
+		if part.Name != "" && !first {
+			l.Parts = append(l.Parts, part)
+		}
+	}
+	// lines without an end last until the next one starts
+	for i := range l.Lines {
+		if l.Lines[i].End == 0 && i+1 < len(l.Lines) {
+			l.Lines[i].End = l.Lines[i+1].Begin
+		}
+	}
+	return l, nil
+}
+
+// parseMetadata reads the agents, songwriters, translations and
+// transliterations from the head of the document.
+func (l *Lyrics) parseMetadata(metadata *etree.Element) error {
+	for _, a := range metadata.SelectElements("ttm:agent") {
+		agent := Agent{ID: a.SelectAttrValue("xml:id", ""), Type: a.SelectAttrValue("type", "")}
+		if name := a.SelectElement("ttm:name"); name != nil {
+			agent.Name = strings.TrimSpace(name.Text())
+		}
+		l.Agents = append(l.Agents, agent)
+	}
+	itunes := metadata.SelectElement("iTunesMetadata")
+	if itunes == nil {
+		return nil
+	}
+	for _, w := range itunes.FindElements("songwriters/songwriter") {
+		if name := strings.TrimSpace(w.Text()); name != "" {
+			l.Songwriters = append(l.Songwriters, name)
+		}
+	}
+	for _, t := range itunes.FindElements("translations/translation") {
+		translation, err := parseTranslation(t)
+		if err != nil {
+			return err
+		}
+		l.Translations = append(l.Translations, translation)
+	}
+	for _, t := range itunes.FindElements("transliterations/transliteration") {
+		translation, err := parseTranslation(t)
+		if err != nil {
+			return err
+		}
+		l.Transliterations = append(l.Transliterations, translation)
+	}
+	return nil
+}
+
+func parseTranslation(e *etree.Element) (Translation, error) {
+	t := Translation{
+		Language: e.SelectAttrValue("xml:lang", ""),
+		Type:     e.SelectAttrValue("type", ""),
+		Lines:    make(map[string]TextLine),
+	}
+	for _, text := range e.SelectElements("text") {
+		var line TextLine
+		if attr := text.SelectAttr("text"); attr != nil {
+			line.Text = attr.Value
+		} else {
+			var err error
+			if line.Words, _, err = parseWords(text); err != nil {
+				return t, err
+			}
+			line.Text = elementText(text)
+		}
+		t.Lines[text.SelectAttrValue("for", "")] = line
+	}
+	return t, nil
+}
+
+func parseLine(p *etree.Element, timing string) (Line, error) {
+	line := Line{Key: p.SelectAttrValue("itunes:key", ""), Agent: p.SelectAttrValue("ttm:agent", "")}
+	var err error
+	if timing != "None" {
+		begin := p.SelectAttr("begin")
+		if begin == nil {
+			return line, errors.New("no synchronised lyrics")
+		}
+		if line.Begin, err = parseTime(begin.Value); err != nil {
+			return line, err
+		}
+		if end := p.SelectAttrValue("end", ""); end != "" {
+			if line.End, err = parseTime(end); err != nil {
+				return line, err
+			}
+		}
+	}
+	if timing == "Word" {
+		if line.Words, line.Background, err = parseWords(p); err != nil {
+			return line, err
+		}
+		line.Text = wordsText(line.Words)
+		if line.End == 0 && len(line.Words) > 0 {
+			line.End = line.Words[len(line.Words)-1].End
+		}
+		if line.Text != "" || len(line.Background) > 0 {
+			return line, nil
+		}
+	}
+	if text := p.SelectAttr("text"); text != nil {
+		line.Text = strings.TrimSpace(text.Value)
+	} else {
+		line.Text = strings.TrimSpace(mainText(p))
+	}
+	return line, nil
+}
+
+// parseWords returns the timed spans below e in document order, and those
+// of the background vocals (ttm:role="x-bg") separately.
+func parseWords(e *etree.Element) (words []Word, background []Word, err error) {
+	for _, child := range e.Child {
+		switch c := child.(type) {
+		case *etree.CharData:
+			if len(words) > 0 && strings.TrimSpace(c.Data) == "" {
+				words[len(words)-1].Space = true
+			}
+		case *etree.Element:
+			if c.SelectAttrValue("ttm:role", "") == "x-bg" {
+				inner, _, err := parseWords(c)
+				if err != nil {
+					return nil, nil, err
+				}
+				background = append(background, inner...)
+				continue
+			}
+			if c.SelectAttr("begin") == nil {
+				// spans grouping other spans
+				inner, bg, err := parseWords(c)
+				if err != nil {
+					return nil, nil, err
+				}
+				words = append(words, inner...)
+				background = append(background, bg...)
+				continue
+			}
+			begin, err := parseTime(c.SelectAttrValue("begin", ""))
+			if err != nil {
+				return nil, nil, err
+			}
+			end, err := parseTime(c.SelectAttrValue("end", ""))
+			if err != nil {
+				return nil, nil, err
+			}
+			words = append(words, Word{Begin: begin, End: end, Text: elementText(c)})
+		}
+	}
+	return words, background, nil
+}
+
+// wordsText joins words into the text of a line.
+func wordsText(words []Word) string {
+	var b strings.Builder
+	for _, w := range words {
+		b.WriteString(w.Text)
+		if w.Space {
+			b.WriteString(" ")
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// elementText is the text of e and its children.
+func elementText(e *etree.Element) string {
+	var b strings.Builder
+	for _, child := range e.Child {
+		switch c := child.(type) {
+		case *etree.CharData:
+			b.WriteString(c.Data)
+		case *etree.Element:
+			b.WriteString(elementText(c))
+		}
+	}
+	return b.String()
+}
+
+// mainText is the text of e without its background vocals.
+func mainText(e *etree.Element) string {
+	var b strings.Builder
+	for _, child := range e.Child {
+		switch c := child.(type) {
+		case *etree.CharData:
+			b.WriteString(c.Data)
+		case *etree.Element:
+			if c.SelectAttrValue("ttm:role", "") != "x-bg" {
+				b.WriteString(mainText(c))
+			}
+		}
+	}
+	return b.String()
+}
+
+// parseTime reads a TTML clock time such as "1:02:03.456", "02:03.456",
+// "3.456" or "3.456s".
+func parseTime(value string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimSpace(value), "s"), ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	total := time.Duration(seconds*1000+0.5) * time.Millisecond
+	scale := time.Minute
+	for i := len(parts) - 2; i >= 0; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		total += time.Duration(n) * scale
+		scale = time.Hour
+	}
+	return total, nil
+}
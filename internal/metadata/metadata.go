@@ -0,0 +1,208 @@
+// Package metadata normalizes what the catalog knows about a track into one
+// model that tag writers and file names are built from.
+package metadata
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"main/internal/api"
+	"main/internal/task"
+)
+
+// TrackMetadata describes one track or music video. Fields left empty are
+// not written by the taggers.
+type TrackMetadata struct {
+	ID                 string
+	Title              string
+	Artist             string   // display name, e.g. "A & B"
+	Artists            []string // every credited artist
+	ArtistID           string
+	AlbumArtist        string
+	Album              string
+	AlbumID            string
+	Composer           string   // display name
+	Composers          []string // split from the display name
+	Genre              string
+	TrackNumber        int
+	TrackTotal         int
+	DiscNumber         int
+	DiscTotal          int
+	Date               string // release date of the album
+	ReleaseTime        string // release date of the track
+	Copyright          string
+	Label              string
+	UPC                string
+	ISRC               string
+	Advisory           string // explicit, clean or empty
+	AppleDigitalMaster bool
+	ArtworkURL         string
+	Picture            []byte
+	Lyrics             string
+	SyncedLyrics       []SyncedLine
+}
+
+// SyncedLine is one timed lyrics line, in milliseconds from the start.
+type SyncedLine struct {
+	Time int
+	Text string
+}
+
+// FromTrack builds the metadata of track. Tracks of playlists and stations
+// are numbered by their place in the list unless songInfoForPlaylist is set,
+// in which case they carry the data of their album like album tracks do.
+// Tracks of multi-disc albums count the tracks of their disc when discFolders
+// is set, as each disc then has a folder of its own.
+func FromTrack(track *task.Track, songInfoForPlaylist, discFolders bool) *TrackMetadata {
+	attrs := track.Resp.Attributes
+	meta := &TrackMetadata{
+		ID:                 track.ID,
+		Title:              attrs.Name,
+		Artist:             attrs.ArtistName,
+		Album:              attrs.AlbumName,
+		Composer:           attrs.ComposerName,
+		Composers:          splitNames(attrs.ComposerName),
+		TrackNumber:        attrs.TrackNumber,
+		DiscNumber:         attrs.DiscNumber,
+		ReleaseTime:        attrs.ReleaseDate,
+		ISRC:               attrs.Isrc,
+		Advisory:           attrs.ContentRating,
+		AppleDigitalMaster: attrs.IsAppleDigitalMaster,
+		ArtworkURL:         attrs.Artwork.URL,
+	}
+	if len(attrs.GenreNames) > 0 {
+		meta.Genre = attrs.GenreNames[0]
+	}
+	for _, artist := range track.Resp.Relationships.Artists.Data {
+		if artist.Attributes.Name != "" {
+			meta.Artists = append(meta.Artists, artist.Attributes.Name)
+		}
+	}
+	if len(meta.Artists) == 0 && meta.Artist != "" {
+		meta.Artists = []string{meta.Artist}
+	}
+	if len(track.Resp.Relationships.Artists.Data) > 0 {
+		meta.ArtistID = track.Resp.Relationships.Artists.Data[0].ID
+	}
+	if track.PreType == "albums" {
+		meta.AlbumID = track.PreID
+	}
+
+	if (track.PreType == "playlists" || track.PreType == "stations") && !songInfoForPlaylist {
+		meta.DiscNumber = 1
+		meta.DiscTotal = 1
+		meta.TrackNumber = track.TaskNum
+		meta.TrackTotal = track.TaskTotal
+		meta.Album = track.PlaylistData.Attributes.Name
+		meta.AlbumArtist = track.PlaylistData.Attributes.ArtistName
+		return meta
+	}
+	album := track.AlbumData.Attributes
+	meta.DiscTotal = track.DiscTotal
+	meta.TrackTotal = album.TrackCount
+	if discFolders && meta.DiscTotal > 1 {
+		if n := discTracks(track.AlbumData, meta.DiscNumber); n > 0 {
+			meta.TrackTotal = n
+		}
+	}
+	meta.AlbumArtist = album.ArtistName
+	meta.UPC = album.Upc
+	meta.Date = album.ReleaseDate
+	meta.Copyright = album.Copyright
+	meta.Label = album.RecordLabel
+	return meta
+}
+
+// discTracks counts the tracks of disc in album.
+func discTracks(album api.AlbumRespData, disc int) int {
+	n := 0
+	for _, t := range album.Relationships.Tracks.Data {
+		if t.Attributes.DiscNumber == disc {
+			n++
+		}
+	}
+	return n
+}
+
+// FromMusicVideo builds the metadata of a music video. track is the album or
+// playlist entry it was found in, or nil for a video downloaded on its own.
+func FromMusicVideo(mv *api.MusicVideoRespData, track *task.Track, songInfoForPlaylist, discFolders bool) *TrackMetadata {
+	attrs := mv.Attributes
+	meta := &TrackMetadata{
+		ID:          mv.ID,
+		Album:       attrs.AlbumName,
+		TrackNumber: attrs.TrackNumber,
+		DiscNumber:  attrs.DiscNumber,
+	}
+	if track != nil {
+		meta = FromTrack(track, songInfoForPlaylist, discFolders)
+	}
+	meta.Title = attrs.Name
+	meta.Artist = attrs.ArtistName
+	meta.ReleaseTime = attrs.ReleaseDate
+	meta.ISRC = attrs.Isrc
+	meta.Advisory = attrs.ContentRating
+	meta.ArtworkURL = attrs.Artwork.URL
+	if len(attrs.GenreNames) > 0 {
+		meta.Genre = attrs.GenreNames[0]
+	}
+	var artists []string
+	for _, artist := range mv.Relationships.Artists.Data {
+		if artist.Attributes.Name != "" {
+			artists = append(artists, artist.Attributes.Name)
+		}
+	}
+	if len(artists) == 0 && meta.Artist != "" {
+		artists = []string{meta.Artist}
+	}
+	meta.Artists = artists
+	return meta
+}
+
+// SetLyrics stores lrc as the lyrics and its timed lines as synced lyrics.
+func (m *TrackMetadata) SetLyrics(lrc string) {
+	m.Lyrics = lrc
+	m.SyncedLyrics = ParseLrc(lrc)
+}
+
+var nameSeparator = regexp.MustCompile(`\s*(?:,|&)\s*`)
+
+// splitNames splits a display name such as "A, B & C" into its names.
+func splitNames(s string) []string {
+	var names []string
+	for _, name := range nameSeparator.Split(s, -1) {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+var lrcLine = regexp.MustCompile(`^\[(\d+):(\d+)(?:\.(\d+))?\](.*)$`)
+var lrcWord = regexp.MustCompile(`<\d+:\d+(?:\.\d+)?>`)
+
+// ParseLrc returns the timed lines of an LRC text, or nil if it has none.
+// Word timings of syllable lyrics are dropped.
+func ParseLrc(lrc string) []SyncedLine {
+	var lines []SyncedLine
+	for _, line := range strings.Split(lrc, "\n") {
+		m := lrcLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		min, _ := strconv.Atoi(m[1])
+		sec, _ := strconv.Atoi(m[2])
+		frac := 0
+		if m[3] != "" {
+			// "5" is 500ms, "05" 50ms, "005" 5ms
+			digits := (m[3] + "00")[:3]
+			frac, _ = strconv.Atoi(digits)
+		}
+		lines = append(lines, SyncedLine{
+			Time: (min*60+sec)*1000 + frac,
+			Text: strings.TrimSpace(lrcWord.ReplaceAllString(m[4], "")),
+		})
+	}
+	return lines
+}
@@ -0,0 +1,62 @@
+package metadata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"main/internal/api"
+	"main/internal/task"
+)
+
+// album returns an album with the given number of tracks on each disc.
+func album(t *testing.T, discs ...int) api.AlbumRespData {
+	t.Helper()
+	var tracks []map[string]any
+	total := 0
+	for i, n := range discs {
+		for j := 1; j <= n; j++ {
+			tracks = append(tracks, map[string]any{"attributes": map[string]any{"discNumber": i + 1, "trackNumber": j}})
+		}
+		total += n
+	}
+	data, err := json.Marshal(map[string]any{
+		"attributes":    map[string]any{"trackCount": total},
+		"relationships": map[string]any{"tracks": map[string]any{"data": tracks}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a api.AlbumRespData
+	if err := json.Unmarshal(data, &a); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestFromTrackTotals(t *testing.T) {
+	tests := []struct {
+		name        string
+		discs       []int
+		disc        int
+		discFolders bool
+		want        int
+	}{
+		{"one disc", []int{10}, 1, false, 10},
+		{"one disc, disc folders", []int{10}, 1, true, 10},
+		{"two discs", []int{10, 6}, 2, false, 16},
+		{"two discs, disc folders", []int{10, 6}, 2, true, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			track := &task.Track{PreType: "albums", DiscTotal: len(tt.discs), AlbumData: album(t, tt.discs...)}
+			track.Resp.Attributes.DiscNumber = tt.disc
+			meta := FromTrack(track, false, tt.discFolders)
+			if meta.TrackTotal != tt.want {
+				t.Errorf("TrackTotal = %d, want %d", meta.TrackTotal, tt.want)
+			}
+			if meta.DiscTotal != len(tt.discs) {
+				t.Errorf("DiscTotal = %d, want %d", meta.DiscTotal, len(tt.discs))
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+// Package naming renders the folder and file name formats of the config.
+//
+// A format is a text/template over Fields, e.g.
+//
+//	{{.AlbumArtist}} - {{.AlbumName}}{{if gt .DiscTotal 1}}/Disc {{.DiscNumber}}{{end}}
+//
+// Formats without "{{" use the older placeholder syntax ("{AlbumName}"),
+// which is translated to the same template. A "/" in the rendered name starts
+// a sub folder; every segment is cleaned of characters the file system does
+// not accept.
+package naming
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"main/internal/api"
+	"main/internal/metadata"
+	"main/internal/task"
+	"main/internal/utils"
+)
+
+// Fields are the values a format can use. Albums, playlists, stations and
+// music videos all fill the same set; what does not apply stays empty.
+type Fields struct {
+	Kind          string // album, playlist, station or music-video
+	ArtistName    string // album artist for folders, track artist for files
+	ArtistId      string
+	UrlArtistName string // artist the run started from, else ArtistName
+	AlbumArtist   string
+	AlbumName     string
+	AlbumId       string
+	PlaylistName  string
+	PlaylistId    string
+	ReleaseDate   string
+	ReleaseYear   string
+	UPC           string
+	RecordLabel   string
+	Copyright     string
+	Genre         string
+	SongName      string
+	SongId        string
+	ISRC          string
+	SongNumber    int // position in the album or playlist being downloaded
+	TrackNumber   int
+	TrackTotal    int
+	DiscNumber    int
+	DiscTotal     int
+	Explicit      bool
+	Quality       string
+	Codec         string
+	Tag           string
+}
+
+// Album returns the fields of an album.
+func Album(data api.AlbumRespData) Fields {
+	attrs := data.Attributes
+	f := Fields{
+		Kind:          "album",
+		ArtistName:    attrs.ArtistName,
+		UrlArtistName: attrs.ArtistName,
+		AlbumArtist:   attrs.ArtistName,
+		AlbumName:     attrs.Name,
+		AlbumId:       data.ID,
+		UPC:           attrs.Upc,
+		RecordLabel:   attrs.RecordLabel,
+		Copyright:     attrs.Copyright,
+		TrackTotal:    attrs.TrackCount,
+		Explicit:      attrs.ContentRating == "explicit",
+	}
+	f.setReleaseDate(attrs.ReleaseDate)
+	if len(attrs.GenreNames) > 0 {
+		f.Genre = attrs.GenreNames[0]
+	}
+	if len(data.Relationships.Artists.Data) > 0 {
+		f.ArtistId = data.Relationships.Artists.Data[0].ID
+	}
+	return f
+}
+
+// Playlist returns the fields of a playlist or, with kind "station", a
+// station.
+func Playlist(kind string, id string, name string, curator string) Fields {
+	return Fields{
+		Kind:          kind,
+		ArtistName:    curator,
+		UrlArtistName: curator,
+		AlbumArtist:   curator,
+		PlaylistName:  name,
+		PlaylistId:    id,
+	}
+}
+
+// Track returns the fields of track within the album, playlist or station it
+// is downloaded from.
+func Track(track *task.Track, meta *metadata.TrackMetadata) Fields {
+	var f Fields
+	switch track.PreType {
+	case "playlists":
+		f = Playlist("playlist", track.PreID, track.PlaylistData.Attributes.Name, track.PlaylistData.Attributes.ArtistName)
+	case "stations":
+		f = Playlist("station", track.PreID, track.PlaylistData.Attributes.Name, track.PlaylistData.Attributes.ArtistName)
+	default:
+		f = Album(track.AlbumData)
+	}
+	return f.WithTrack(meta, track.TaskNum)
+}
+
+// WithTrack returns f with the fields of one track of it.
+func (f Fields) WithTrack(meta *metadata.TrackMetadata, songNumber int) Fields {
+	f.ArtistName = meta.Artist
+	f.SongName = meta.Title
+	f.SongId = meta.ID
+	f.ISRC = meta.ISRC
+	f.SongNumber = songNumber
+	f.TrackNumber = meta.TrackNumber
+	f.TrackTotal = meta.TrackTotal
+	f.DiscNumber = meta.DiscNumber
+	f.DiscTotal = meta.DiscTotal
+	f.Explicit = meta.Advisory == "explicit"
+	if meta.Genre != "" {
+		f.Genre = meta.Genre
+	}
+	if f.AlbumName == "" {
+		f.AlbumName = meta.Album
+		f.AlbumArtist = meta.AlbumArtist
+	}
+	if f.UPC == "" {
+		f.UPC = meta.UPC
+		f.RecordLabel = meta.Label
+		f.Copyright = meta.Copyright
+	}
+	if f.ReleaseDate == "" {
+		f.setReleaseDate(meta.Date)
+	}
+	return f
+}
+
+// Limit truncates the names in f to max characters, as limit-max asks.
+func (f Fields) Limit(max int) Fields {
+	for _, s := range []*string{&f.ArtistName, &f.UrlArtistName, &f.AlbumArtist, &f.AlbumName, &f.PlaylistName, &f.SongName} {
+		*s = utils.LimitString(*s, max)
+	}
+	return f
+}
+
+func (f *Fields) setReleaseDate(date string) {
+	f.ReleaseDate = date
+	if len(date) >= 4 {
+		f.ReleaseYear = date[:4]
+	}
+}
+
+var funcs = template.FuncMap{
+	// pad zero-pads n to width digits: {{pad 2 .TrackNumber}}
+	"pad": func(width int, n int) string {
+		return fmt.Sprintf("%0*d", width, n)
+	},
+	// truncate cuts s to max characters: {{truncate 40 .AlbumName}}
+	"truncate": func(max int, s string) string {
+		return utils.LimitString(s, max)
+	},
+	// default returns def when s is empty: {{default "Unknown" .RecordLabel}}
+	"default": func(def string, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"trim":  strings.TrimSpace,
+}
+
+// legacy maps the old placeholders that do not match a field name.
+var legacy = map[string]string{
+	"SongNumer":  "{{pad 2 .SongNumber}}",
+	"SongNumber": "{{pad 2 .SongNumber}}",
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// translate turns a placeholder format into a template.
+func translate(format string) string {
+	if strings.Contains(format, "{{") {
+		return format
+	}
+	return placeholder.ReplaceAllStringFunc(format, func(m string) string {
+		name := m[1 : len(m)-1]
+		if t, ok := legacy[name]; ok {
+			return t
+		}
+		return "{{." + name + "}}"
+	})
+}
+
+var (
+	mu     sync.Mutex
+	parsed = make(map[string]*template.Template)
+)
+
+func parse(format string) (*template.Template, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if t, ok := parsed[format]; ok {
+		return t, nil
+	}
+	t, err := template.New("name").Funcs(funcs).Parse(translate(format))
+	if err != nil {
+		return nil, err
+	}
+	parsed[format] = t
+	return t, nil
+}
+
+// Check parses format and runs it over empty fields, so a typo in a field
+// name is reported before anything is downloaded.
+func Check(format string) error {
+	t, err := parse(format)
+	if err != nil {
+		return err
+	}
+	return t.Execute(&strings.Builder{}, Fields{})
+}
+
+// Bind fills field of format with a fixed value, for values that are known
+// before the per-item fields are, such as the artist of an artist URL. The
+// value is escaped like the values of Render.
+func Bind(format string, field string, value string) string {
+	re := regexp.MustCompile(`\.` + field + `\b`)
+	return re.ReplaceAllString(translate(format), "("+strconv.Quote(escape(value))+")")
+}
+
+// Render executes format over f and returns the cleaned relative path.
+func Render(format string, f Fields) (string, error) {
+	t, err := parse(format)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, f.escaped()); err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(Clean(b.String())), nil
+}
+
+// escaped replaces the "/" in the values of f, so only the ones written in
+// the format start a sub folder.
+func (f Fields) escaped() Fields {
+	v := reflect.ValueOf(&f).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if field := v.Field(i); field.Kind() == reflect.String {
+			field.SetString(escape(field.String()))
+		}
+	}
+	return f
+}
+
+// escape replaces the "/" in a value.
+func escape(value string) string {
+	return strings.ReplaceAll(value, "/", "_")
+}
@@ -0,0 +1,84 @@
+package naming
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	f := Fields{
+		Kind:        "album",
+		ArtistName:  "AC/DC",
+		AlbumArtist: "AC/DC",
+		AlbumName:   "Back in Black",
+		ReleaseYear: "1980",
+		SongName:    "Hells Bells",
+		SongNumber:  1,
+		TrackNumber: 1,
+		DiscNumber:  2,
+		DiscTotal:   2,
+	}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"{AlbumName} ({ReleaseYear})", "Back in Black (1980)"},
+		{"{SongNumer}. {SongName}", "01. Hells Bells"},
+		{"{ArtistName}/{AlbumName}", "AC_DC/Back in Black"},
+		{"{{.AlbumArtist}} - {{.AlbumName}}", "AC_DC - Back in Black"},
+		{"{{.AlbumName}}{{if gt .DiscTotal 1}}/Disc {{.DiscNumber}}{{end}}", "Back in Black/Disc 2"},
+		{`{{pad 3 .TrackNumber}} {{upper .SongName}}`, "001 HELLS BELLS"},
+		{`{{default "Unknown" .RecordLabel}}`, "Unknown"},
+		{"{AlbumName}: {SongName}?", "Back in Black_ Hells Bells_"},
+		{"{RecordLabel}/{AlbumName}", "Back in Black"},
+	}
+	for _, tt := range tests {
+		got, err := Render(tt.format, f)
+		if err != nil {
+			t.Errorf("Render(%q): %v", tt.format, err)
+			continue
+		}
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("Render(%q) = %q, want %q", tt.format, got, want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		format string
+		ok     bool
+	}{
+		{"{AlbumName}", true},
+		{"{{.AlbumName}}", true},
+		{"{AlbumNam}", false},
+		{"{{.AlbumName", false},
+	}
+	for _, tt := range tests {
+		if err := Check(tt.format); (err == nil) != tt.ok {
+			t.Errorf("Check(%q) = %v, want ok %v", tt.format, err, tt.ok)
+		}
+	}
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		format, field, value string
+		want                 string
+	}{
+		{"{UrlArtistName}", "UrlArtistName", "AC/DC", "AC_DC"},
+		{"{{.UrlArtistName}} ({{.ArtistId}})", "UrlArtistName", `Say "Hi"`, `Say _Hi_ ()`},
+		{"{UrlArtistName}/{AlbumName}", "UrlArtistName", "Bob", "Bob/Album"},
+		{"{ArtistName}", "UrlArtistName", "Bob", "Artist"},
+	}
+	for _, tt := range tests {
+		got, err := Render(Bind(tt.format, tt.field, tt.value), Fields{ArtistName: "Artist", AlbumName: "Album"})
+		if err != nil {
+			t.Errorf("Render(Bind(%q, %q, %q)): %v", tt.format, tt.field, tt.value, err)
+			continue
+		}
+		if want := filepath.FromSlash(tt.want); got != want {
+			t.Errorf("Render(Bind(%q, %q, %q)) = %q, want %q", tt.format, tt.field, tt.value, got, want)
+		}
+	}
+}
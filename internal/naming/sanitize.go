@@ -0,0 +1,216 @@
+package naming
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"main/internal/structs"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Limits of the file system the downloads are written to. Configure sets them
+// from the config.
+var (
+	// NameBytes caps every file and folder name, in bytes. Most file systems
+	// allow 255, eCryptfs about 143.
+	NameBytes = 255
+	// PathBytes caps whole paths, in bytes; 0 means no cap.
+	PathBytes = 0
+	// Normalization is applied to every name; nil keeps names as they are.
+	Normalization = norm.NFC.String
+)
+
+const (
+	// suffixBytes is kept free after a file name for its extension and for
+	// side files such as ".lrc" or "_thumbnail.jpg".
+	suffixBytes = 16
+	// fileBytes is kept free below a folder for the files put into it.
+	fileBytes = 64
+	// minBytes is as short as a name is cut to fit a path budget.
+	minBytes = 8
+)
+
+// Configure applies the name limits of cfg.
+func Configure(cfg *structs.ConfigSet) error {
+	if cfg.MaxNameBytes > 0 {
+		NameBytes = cfg.MaxNameBytes
+	}
+	PathBytes = cfg.MaxPathBytes
+	switch strings.ToLower(cfg.UnicodeNormalization) {
+	case "", "nfc":
+		Normalization = norm.NFC.String
+	case "nfd":
+		Normalization = norm.NFD.String
+	case "none":
+		Normalization = nil
+	default:
+		return fmt.Errorf("invalid unicode-normalization %q, use nfc, nfd or none", cfg.UnicodeNormalization)
+	}
+	return nil
+}
+
+var forbidden = strings.NewReplacer(`\`, "_", "<", "_", ">", "_", ":", "_", `"`, "_", "|", "_", "?", "_", "*", "_", "/", "_")
+
+// reserved are the device names Windows does not allow as a name, with or
+// without an extension.
+var reserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+	"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+	"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// CleanName makes s a valid file or folder name on Windows, macOS and Linux.
+// A "/" in s is replaced like the other characters that are not allowed.
+// The result may be empty.
+func CleanName(s string) string {
+	if Normalization != nil {
+		s = Normalization(s)
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t' || r == '\n' || r == '\r':
+			return ' '
+		case unicode.IsControl(r) || r == utf8.RuneError:
+			return -1
+		}
+		return r
+	}, s)
+	s = forbidden.Replace(s)
+	s = trimName(cutBytes(s, NameBytes))
+	stem, ext, _ := strings.Cut(s, ".")
+	if reserved[strings.ToUpper(strings.TrimSpace(stem))] {
+		s = strings.TrimSpace(stem) + "_"
+		if ext != "" {
+			s += "." + ext
+		}
+	}
+	return s
+}
+
+// Clean makes every "/" separated segment of name a valid file name and
+// drops the empty ones.
+func Clean(name string) string {
+	var segments []string
+	for _, s := range strings.Split(name, "/") {
+		if s = CleanName(s); s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// trimName drops the spaces around s and the dots and spaces Windows does not
+// allow at its end.
+func trimName(s string) string {
+	return strings.TrimRight(strings.TrimSpace(s), ". ")
+}
+
+// cutBytes cuts s to at most max bytes without splitting a character.
+func cutBytes(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max]
+}
+
+// Dir returns the folder name, a cleaned relative path as Render returns it,
+// joined to parent and shortened so that the path leaves room for the files
+// put into it. An empty name is no folder and returns parent.
+func Dir(parent string, name string) string {
+	if name == "" {
+		return parent
+	}
+	return filepath.Join(parent, fit(parent, name, "", 0, fileBytes))
+}
+
+var (
+	claimMu sync.Mutex
+	claimed = make(map[string]string)
+)
+
+// File returns the file name, without its extension, that owner gets for
+// name in dir: name shortened to the name and path limits, and numbered
+// " (2)", " (3)" if another owner of this run or a file claimed with Claim
+// already has it. Names claimed by any of aliases, such as the ISRC of a
+// track, count as owner's too. Names are compared the way case insensitive
+// file systems do.
+func File(dir string, name string, owner string, aliases ...string) string {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+	for n := 1; ; n++ {
+		suffix := ""
+		if n > 1 {
+			suffix = fmt.Sprintf(" (%d)", n)
+		}
+		file := fit(dir, name, suffix, suffixBytes, suffixBytes)
+		key := claimKey(dir, file)
+		if o, ok := claimed[key]; !ok || o == owner || slices.Contains(aliases, o) {
+			claimed[key] = owner
+			return file
+		}
+	}
+}
+
+// Claim gives file in dir, a name File returned, to owner, such as the track
+// an existing file of an earlier run belongs to. File then numbers the name
+// for everyone else, whichever order they ask in.
+func Claim(dir string, file string, owner string) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+	claimed[claimKey(dir, file)] = owner
+}
+
+func claimKey(dir string, file string) string {
+	return strings.ToLower(norm.NFC.String(filepath.Join(dir, file)))
+}
+
+// fit shortens the segments of the relative path name, last first, until
+// each one fits NameBytes and dir/name fits PathBytes. suffix is appended to
+// the last segment and kept whole; nameReserve and pathReserve bytes are
+// kept free after the last segment and after the path.
+func fit(dir string, name string, suffix string, nameReserve int, pathReserve int) string {
+	segments := strings.Split(filepath.ToSlash(name), "/")
+	last := len(segments) - 1
+	for i, s := range segments {
+		max := NameBytes
+		if i == last {
+			max -= len(suffix) + nameReserve
+		}
+		if max < minBytes {
+			max = minBytes
+		}
+		segments[i] = trimName(cutBytes(s, max))
+	}
+	if PathBytes > 0 {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		over := len(dir) + 1 + len(strings.Join(segments, "/")) + len(suffix) + pathReserve - PathBytes
+		for i := last; i >= 0 && over > 0; i-- {
+			keep := len(segments[i]) - over
+			if keep < minBytes {
+				keep = minBytes
+			}
+			before := len(segments[i])
+			segments[i] = trimName(cutBytes(segments[i], keep))
+			over -= before - len(segments[i])
+		}
+	}
+	for i, s := range segments {
+		if s == "" {
+			segments[i] = "_"
+		}
+	}
+	return filepath.FromSlash(strings.Join(segments, "/") + suffix)
+}
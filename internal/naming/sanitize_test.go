@@ -0,0 +1,104 @@
+package naming
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCleanName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"AC/DC", "AC_DC"},
+		{`What? "Now" <Live>`, "What_ _Now_ _Live_"},
+		{"Tab\tand\nnewline", "Tab and newline"},
+		{"  Trailing dots... ", "Trailing dots"},
+		{"CON", "CON_"},
+		{"nul.txt", "nul_.txt"},
+		{"Console", "Console"},
+		{"\x00\x1f", ""},
+	}
+	for _, tt := range tests {
+		if got := CleanName(tt.in); got != tt.want {
+			t.Errorf("CleanName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Artist/Album", "Artist/Album"},
+		{"/Artist//Album/", "Artist/Album"},
+		{"Artist/ . /Album", "Artist/Album"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Clean(tt.in); got != tt.want {
+			t.Errorf("Clean(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	defer func(n, p int) { NameBytes, PathBytes = n, p }(NameBytes, PathBytes)
+	NameBytes, PathBytes = 255, 0
+	parent := filepath.FromSlash("/music/ALAC")
+	tests := []struct {
+		name, want string
+	}{
+		{"", "/music/ALAC"},
+		{"Artist", "/music/ALAC/Artist"},
+		{"Artist/Album", "/music/ALAC/Artist/Album"},
+		{strings.Repeat("a", 300), "/music/ALAC/" + strings.Repeat("a", 255)},
+	}
+	for _, tt := range tests {
+		if got, want := Dir(parent, tt.name), filepath.FromSlash(tt.want); got != want {
+			t.Errorf("Dir(%q, %q) = %q, want %q", parent, tt.name, got, want)
+		}
+	}
+
+	PathBytes = 100
+	if got := Dir(parent, strings.Repeat("b", 200)); len(got)+fileBytes > PathBytes {
+		t.Errorf("Dir = %d bytes, want room for %d more within %d", len(got), fileBytes, PathBytes)
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name, owner, want string
+	}{
+		{"Song", "1", "Song"},
+		{"Song", "1", "Song"},
+		{"song", "2", "song (2)"},
+		{"Song", "3", "Song (3)"},
+		{"Other", "2", "Other"},
+	}
+	for _, tt := range tests {
+		if got := File(dir, tt.name, tt.owner); got != tt.want {
+			t.Errorf("File(%q, %q) = %q, want %q", tt.name, tt.owner, got, tt.want)
+		}
+	}
+}
+
+func TestClaim(t *testing.T) {
+	dir := t.TempDir()
+	// a file of an earlier run belongs to the track with ISRC B
+	Claim(dir, "Intro", "isrc:B")
+	tests := []struct {
+		owner, alias, want string
+	}{
+		{"1", "isrc:A", "Intro (2)"},
+		{"2", "isrc:B", "Intro"},
+		{"3", "isrc:C", "Intro (3)"},
+		{"1", "isrc:A", "Intro (2)"},
+	}
+	for _, tt := range tests {
+		if got := File(dir, "Intro", tt.owner, tt.alias); got != tt.want {
+			t.Errorf("File(Intro, %s, %s) = %q, want %q", tt.owner, tt.alias, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"main/internal/report"
+	"main/internal/structs"
+	"main/internal/task"
+	"main/internal/utils"
+)
+
+// Track states.
+const (
+	Pending     = "pending"
+	Done        = "done"
+	Failed      = "failed"
+	Unavailable = "unavailable"
+)
+
+// Options are the download flags a run was started with, replayed on resume.
+type Options struct {
+	Atmos       bool   `json:"atmos"`
+	AAC         bool   `json:"aac"`
+	AacType     string `json:"aacType"`
+	AlacMax     int    `json:"alacMax"`
+	AtmosMax    int    `json:"atmosMax"`
+	MVAudioType string `json:"mvAudioType"`
+	MVMax       int    `json:"mvMax"`
+}
+
+type Track struct {
+	ID    string `json:"id"`
+	Num   int    `json:"num"`
+	Name  string `json:"name"`
+	State string `json:"state"`
+}
+
+// Item is one queued URL. Albums, playlists, songs and music videos are
+// expanded to their tracks when the manifest is created; stations are not,
+// so their State is tracked on the item itself, as is that of items that
+// could not be expanded. Codec overrides the run options for this item when
+// set. ArtistName and ArtistID are set for items of an artist URL, whose
+// folder is named after it.
+type Item struct {
+	URL        string  `json:"url"`
+	Codec      string  `json:"codec,omitempty"`
+	ArtistName string  `json:"artistName,omitempty"`
+	ArtistID   string  `json:"artistId,omitempty"`
+	Kind       string  `json:"kind"`
+	ID         string  `json:"id"`
+	Name       string  `json:"name,omitempty"`
+	State      string  `json:"state"`
+	Tracks     []Track `json:"tracks,omitempty"`
+}
+
+// Manifest is the on-disk job description of a run. Every state change is
+// written through a temp file and rename, so a killed run leaves the last
+// consistent state behind.
+type Manifest struct {
+	Created time.Time `json:"created"`
+	Options Options   `json:"options"`
+	Items   []*Item   `json:"items"`
+
+	path     string
+	readOnly bool
+	mu       sync.Mutex
+}
+
+// DefaultPath places the manifest next to the download folders.
+func DefaultPath(cfg *structs.ConfigSet) string {
+	if cfg.ManifestFile != "" {
+		return cfg.ManifestFile
+	}
+	return filepath.Join(filepath.Dir(filepath.Clean(cfg.AlacSaveFolder)), "job.json")
+}
+
+// Create expands items to their tracks and writes a fresh manifest to path.
+// Only URL, Codec and the artist of the given items are used. An unfinished
+// manifest at path is moved aside rather than overwritten.
+func Create(path string, items []Item, opts Options, token string, language string) (*Manifest, error) {
+	if err := setAside(path); err != nil {
+		return nil, err
+	}
+	m := &Manifest{Created: time.Now(), Options: opts, path: path}
+	for _, it := range items {
+		item := &Item{URL: it.URL, Codec: it.Codec, ArtistName: it.ArtistName, ArtistID: it.ArtistID, State: Pending}
+		expand(item, token, language)
+		m.Items = append(m.Items, item)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m, m.save()
+}
+
+// setAside renames the manifest at path to one named after its creation time
+// if it still has work left, so that it can be resumed later.
+func setAside(path string) error {
+	old, err := Load(path)
+	if err != nil || len(old.Remaining()) == 0 {
+		return nil
+	}
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext) + "-" + old.Created.Format("20060102-150405")
+	aside := base + ext
+	for i := 2; ; i++ {
+		if exists, _ := utils.FileExists(aside); !exists {
+			break
+		}
+		aside = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	if err := os.Rename(path, aside); err != nil {
+		return fmt.Errorf("keep unfinished job manifest: %w", err)
+	}
+	fmt.Fprintf(report.Out, "The unfinished job manifest was moved to %s, run `amdl resume %s` to finish it.\n", aside, aside)
+	return nil
+}
+
+func expand(item *Item, token string, language string) {
+	switch {
+	case strings.Contains(item.URL, "/music-video/"):
+		_, item.ID = utils.CheckUrlMv(item.URL)
+		item.Kind = "music-video"
+		item.Tracks = []Track{{ID: item.ID, Num: 1, State: Pending}}
+	case strings.Contains(item.URL, "/song/"):
+		_, item.ID = utils.CheckUrlSong(item.URL)
+		item.Kind = "song"
+		item.Tracks = []Track{{ID: item.ID, Num: 1, State: Pending}}
+	case strings.Contains(item.URL, "/album/"):
+		var storefront string
+		storefront, item.ID = utils.CheckUrl(item.URL)
+		item.Kind = "album"
+		album := task.NewAlbum(storefront, item.ID)
+		if err := album.GetResp(token, language); err != nil {
+			fmt.Fprintln(report.Out, "Failed to expand album:", err)
+			return
+		}
+		item.Name = album.Name
+		item.Tracks = tracksOf(album.Tracks)
+		// a song of the album, as song search results link it, rips only
+		// that track
+		if u, err := url.Parse(item.URL); err == nil && u.Query().Get("i") != "" {
+			song := u.Query().Get("i")
+			item.Tracks = []Track{{ID: song, Num: 1, State: Pending}}
+			for _, t := range tracksOf(album.Tracks) {
+				if t.ID == song {
+					item.Tracks = []Track{t}
+				}
+			}
+		}
+	case strings.Contains(item.URL, "/playlist/"):
+		var storefront string
+		storefront, item.ID = utils.CheckUrlPlaylist(item.URL)
+		item.Kind = "playlist"
+		playlist := task.NewPlaylist(storefront, item.ID)
+		if err := playlist.GetResp(token, language); err != nil {
+			fmt.Fprintln(report.Out, "Failed to expand playlist:", err)
+			return
+		}
+		item.Name = playlist.Name
+		item.Tracks = tracksOf(playlist.Tracks)
+	case strings.Contains(item.URL, "/station/"):
+		_, item.ID = utils.CheckUrlStation(item.URL)
+		item.Kind = "station"
+	}
+}
+
+func tracksOf(tracks []task.Track) []Track {
+	list := make([]Track, 0, len(tracks))
+	for _, t := range tracks {
+		list = append(list, Track{ID: t.ID, Num: t.TaskNum, Name: t.Name, State: Pending})
+	}
+	return list
+}
+
+// Load reads the manifest at path.
+func Load(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{path: path}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Path returns the manifest file.
+func (m *Manifest) Path() string {
+	return m.path
+}
+
+// Remaining returns the items that still have pending or failed work.
+func (m *Manifest) Remaining() []Item {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var items []Item
+	for _, item := range m.Items {
+		if !item.complete() {
+			items = append(items, *item)
+		}
+	}
+	return items
+}
+
+func (item *Item) complete() bool {
+	if len(item.Tracks) == 0 {
+		return item.State == Done || item.State == Unavailable
+	}
+	for _, t := range item.Tracks {
+		if t.State == Pending || t.State == Failed {
+			return false
+		}
+	}
+	return true
+}
+
+// Wants reports whether the track of parent (album or playlist ID) still needs
+// to be ripped. Tracks of unknown parents are always wanted.
+func (m *Manifest) Wants(parentID, trackID string) bool {
+	if m == nil {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range m.Items {
+		if item.ID != parentID || len(item.Tracks) == 0 {
+			continue
+		}
+		for _, t := range item.Tracks {
+			if t.ID == trackID {
+				return t.State == Pending || t.State == Failed
+			}
+		}
+		// dropped by an earlier selection
+		return false
+	}
+	return true
+}
+
+// Restrict keeps only the selected tracks of parent, so a resumed run does
+// not ask for the selection again.
+func (m *Manifest) Restrict(parentID string, trackIDs []string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range m.Items {
+		if item.ID != parentID || len(item.Tracks) == 0 {
+			continue
+		}
+		var kept []Track
+		for _, t := range item.Tracks {
+			if utils.Contains(trackIDs, t.ID) {
+				kept = append(kept, t)
+			}
+		}
+		item.Tracks = kept
+		if len(kept) == 0 {
+			item.State = Done
+		}
+	}
+	m.persist()
+}
+
+// Mark sets the state of every queued copy of a track.
+func (m *Manifest) Mark(trackID string, state string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range m.Items {
+		for i := range item.Tracks {
+			if item.Tracks[i].ID == trackID {
+				item.Tracks[i].State = state
+			}
+		}
+		if len(item.Tracks) > 0 && item.complete() {
+			item.State = Done
+		}
+	}
+	m.persist()
+}
+
+// MarkItem records the outcome of an item. Items that were not expanded to
+// tracks, stations and items whose tracks could not be fetched, take the
+// outcome as their state. The tracks of expanded items are tracked by Mark;
+// when the whole item failed, those not done yet are marked failed.
+func (m *Manifest) MarkItem(url string, err error) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, item := range m.Items {
+		if item.URL != url {
+			continue
+		}
+		if len(item.Tracks) == 0 {
+			item.State = Done
+			if err != nil {
+				item.State = Failed
+			}
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		for i := range item.Tracks {
+			if item.Tracks[i].State == Pending {
+				item.Tracks[i].State = Failed
+			}
+		}
+	}
+	m.persist()
+}
+
+// ReadOnly keeps the state changes of m in memory, for dry runs.
+func (m *Manifest) ReadOnly() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.readOnly = true
+}
+
+func (m *Manifest) persist() {
+	if m.readOnly {
+		return
+	}
+	if err := m.save(); err != nil {
+		fmt.Fprintln(report.Out, "Failed to write job manifest:", err)
+	}
+}
+
+func (m *Manifest) save() error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(m.path), os.ModePerm)
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.path)
+}
@@ -0,0 +1,195 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testManifest(t *testing.T) *Manifest {
+	t.Helper()
+	return &Manifest{
+		path: filepath.Join(t.TempDir(), "job.json"),
+		Items: []*Item{
+			{URL: "https://music.apple.com/us/album/a/1", Kind: "album", ID: "1", State: Pending, Tracks: []Track{
+				{ID: "11", Num: 1, State: Pending},
+				{ID: "12", Num: 2, State: Pending},
+			}},
+			{URL: "https://music.apple.com/us/playlist/p/pl.2", Kind: "playlist", ID: "pl.2", State: Pending},
+			{URL: "https://music.apple.com/us/station/s/ra.3", Kind: "station", ID: "ra.3", State: Pending},
+		},
+	}
+}
+
+func TestCreateAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "job.json")
+	items := []Item{
+		{URL: "https://music.apple.com/us/song/s/100", Codec: "aac", ArtistName: "AC/DC", ArtistID: "42"},
+		{URL: "https://music.apple.com/us/music-video/v/200"},
+		{URL: "https://music.apple.com/us/station/s/ra.300"},
+	}
+	m, err := Create(path, items, Options{AAC: true}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Options.AAC || len(loaded.Items) != 3 {
+		t.Fatalf("Load = %+v, want the created manifest", loaded)
+	}
+	tests := []struct {
+		kind, id   string
+		tracks     int
+		artistName string
+	}{
+		{"song", "100", 1, "AC/DC"},
+		{"music-video", "200", 1, ""},
+		{"station", "ra.300", 0, ""},
+	}
+	for i, tt := range tests {
+		item := loaded.Items[i]
+		if item.Kind != tt.kind || item.ID != tt.id || len(item.Tracks) != tt.tracks || item.ArtistName != tt.artistName {
+			t.Errorf("item %d = %+v, want kind %s, id %s, %d tracks, artist %q", i, item, tt.kind, tt.id, tt.tracks, tt.artistName)
+		}
+	}
+	if got := len(m.Remaining()); got != 3 {
+		t.Errorf("Remaining = %d items, want 3", got)
+	}
+}
+
+func TestCreateKeepsUnfinished(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "job.json")
+	if _, err := Create(path, []Item{{URL: "https://music.apple.com/us/station/s/ra.1"}}, Options{}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Create(path, []Item{{URL: "https://music.apple.com/us/station/s/ra.2"}}, Options{}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, "job-*.json"))
+	if len(matches) != 1 {
+		t.Fatalf("set aside manifests = %v, want one", matches)
+	}
+	old, err := Load(matches[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Items[0].ID != "ra.1" {
+		t.Errorf("set aside manifest has %s, want ra.1", old.Items[0].ID)
+	}
+
+	// a finished manifest is simply replaced
+	m, _ := Load(path)
+	m.MarkItem(m.Items[0].URL, nil)
+	if _, err := Create(path, []Item{{URL: "https://music.apple.com/us/station/s/ra.3"}}, Options{}, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(dir, "job-*.json")); len(matches) != 1 {
+		t.Errorf("set aside manifests = %v, want still one", matches)
+	}
+}
+
+func TestMarkAndWants(t *testing.T) {
+	m := testManifest(t)
+	tests := []struct {
+		parent, track string
+		want          bool
+	}{
+		{"1", "11", true},
+		{"1", "13", false}, // dropped by a selection
+		{"pl.2", "99", true},
+		{"unknown", "1", true},
+	}
+	for _, tt := range tests {
+		if got := m.Wants(tt.parent, tt.track); got != tt.want {
+			t.Errorf("Wants(%s, %s) = %v, want %v", tt.parent, tt.track, got, tt.want)
+		}
+	}
+	m.Mark("11", Done)
+	if m.Wants("1", "11") {
+		t.Error("Wants a track marked done")
+	}
+	m.Mark("12", Failed)
+	if !m.Wants("1", "12") {
+		t.Error("does not want a failed track")
+	}
+	m.Mark("12", Done)
+	if m.Items[0].State != Done {
+		t.Errorf("album state = %s after all tracks are done, want done", m.Items[0].State)
+	}
+	if _, err := os.Stat(m.Path()); err != nil {
+		t.Errorf("Mark did not write the manifest: %v", err)
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	m := testManifest(t)
+	m.Restrict("1", []string{"12"})
+	if m.Wants("1", "11") || !m.Wants("1", "12") {
+		t.Errorf("Restrict kept %+v, want only track 12", m.Items[0].Tracks)
+	}
+	m.Restrict("1", nil)
+	if m.Items[0].State != Done {
+		t.Errorf("album state = %s with no tracks selected, want done", m.Items[0].State)
+	}
+}
+
+func TestMarkItem(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		err   error
+		check func(m *Manifest) bool
+	}{
+		{"unexpanded done", "https://music.apple.com/us/playlist/p/pl.2", nil, func(m *Manifest) bool {
+			return m.Items[1].State == Done
+		}},
+		{"unexpanded failed", "https://music.apple.com/us/playlist/p/pl.2", errors.New("x"), func(m *Manifest) bool {
+			return m.Items[1].State == Failed
+		}},
+		{"station", "https://music.apple.com/us/station/s/ra.3", nil, func(m *Manifest) bool {
+			return m.Items[2].State == Done
+		}},
+		{"expanded failed", "https://music.apple.com/us/album/a/1", errors.New("x"), func(m *Manifest) bool {
+			return m.Items[0].Tracks[0].State == Done && m.Items[0].Tracks[1].State == Failed
+		}},
+		{"expanded done", "https://music.apple.com/us/album/a/1", nil, func(m *Manifest) bool {
+			return m.Items[0].Tracks[1].State == Pending
+		}},
+	}
+	for _, tt := range tests {
+		m := testManifest(t)
+		m.Mark("11", Done)
+		m.MarkItem(tt.url, tt.err)
+		if !tt.check(m) {
+			t.Errorf("%s: items after MarkItem = %+v %+v %+v", tt.name, *m.Items[0], *m.Items[1], *m.Items[2])
+		}
+	}
+	m := testManifest(t)
+	m.MarkItem("https://music.apple.com/us/playlist/p/pl.2", errors.New("x"))
+	if got := len(m.Remaining()); got != 3 {
+		t.Errorf("Remaining = %d items, want 3 with a failed item", got)
+	}
+	m.MarkItem("https://music.apple.com/us/playlist/p/pl.2", nil)
+	m.MarkItem("https://music.apple.com/us/station/s/ra.3", nil)
+	if got := len(m.Remaining()); got != 1 {
+		t.Errorf("Remaining = %d items, want 1", got)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	m := testManifest(t)
+	m.ReadOnly()
+	m.Mark("11", Done)
+	m.Restrict("1", []string{"11"})
+	m.MarkItem("https://music.apple.com/us/station/s/ra.3", nil)
+	if _, err := os.Stat(m.Path()); !os.IsNotExist(err) {
+		t.Errorf("a read-only manifest was written: %v", err)
+	}
+	if m.Wants("1", "11") {
+		t.Error("state changes of a read-only manifest are not kept in memory")
+	}
+}
@@ -0,0 +1,178 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"main/internal/structs"
+)
+
+// Track events.
+const (
+	Start           = "start"
+	SkippedExisting = "skipped-existing"
+	Unavailable     = "unavailable"
+	Downloaded      = "downloaded"
+	Tagged          = "tagged"
+	Converted       = "converted"
+	Failed          = "failed"
+)
+
+// Event is one step of a track. In JSON mode every event is written to
+// stdout as a single line.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	ID       string    `json:"id"`
+	ParentID string    `json:"parentId,omitempty"`
+	ISRC     string    `json:"isrc,omitempty"`
+	Name     string    `json:"name,omitempty"`
+	Codec    string    `json:"codec,omitempty"`
+	Quality  string    `json:"quality,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Outcome is the last known state of a track in the run report.
+type Outcome struct {
+	ID       string `json:"id"`
+	ParentID string `json:"parentId,omitempty"`
+	ISRC     string `json:"isrc,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Codec    string `json:"codec,omitempty"`
+	Quality  string `json:"quality,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Summary carries the counters of a finished run.
+type Summary struct {
+	Event    string `json:"event,omitempty"`
+	Total    int    `json:"total"`
+	Success  int    `json:"success"`
+	Warnings int    `json:"warnings"`
+	Errors   int    `json:"errors"`
+}
+
+// Report is the file written at the end of a run.
+type Report struct {
+	Started  time.Time  `json:"started"`
+	Finished time.Time  `json:"finished"`
+	Summary  Summary    `json:"summary"`
+	Tracks   []*Outcome `json:"tracks"`
+}
+
+// Out is where the progress for people is printed: stdout, or stderr in
+// JSON mode, so that stdout carries only events.
+var Out io.Writer = os.Stdout
+
+var (
+	mu      sync.Mutex
+	events  io.Writer
+	file    string
+	started = time.Now()
+	tracks  []*Outcome
+	byID    = make(map[string]*Outcome)
+)
+
+// Configure selects the output format ("text" or "json") and the report
+// file. In JSON mode stdout carries only events and Out is stderr.
+func Configure(format string, reportFile string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	switch format {
+	case "", "text":
+		events, Out = nil, os.Stdout
+	case "json":
+		events, Out = os.Stdout, os.Stderr
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+	file = reportFile
+	return nil
+}
+
+// JSON reports whether events are written to stdout.
+func JSON() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return events != nil
+}
+
+// Emit records e and writes it out in JSON mode.
+func Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	o, ok := byID[e.ParentID+"|"+e.ID]
+	if !ok {
+		o = &Outcome{ID: e.ID, ParentID: e.ParentID}
+		byID[e.ParentID+"|"+e.ID] = o
+		tracks = append(tracks, o)
+	}
+	o.Outcome = e.Event
+	o.Error = e.Error
+	keep(&o.ISRC, e.ISRC)
+	keep(&o.Name, e.Name)
+	keep(&o.Codec, e.Codec)
+	keep(&o.Quality, e.Quality)
+	keep(&o.Path, e.Path)
+	if events != nil {
+		writeLine(e)
+	}
+}
+
+// Finish emits the summary of c and writes the report file if one is set.
+// It may be called again after a retry; the report is rewritten.
+func Finish(c *structs.Counter) error {
+	summary := Summary{
+		Total:    c.Total,
+		Success:  c.Success,
+		Warnings: c.Unavailable + c.NotSong,
+		Errors:   c.Error,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if events != nil {
+		line := summary
+		line.Event = "summary"
+		writeLine(line)
+	}
+	if file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(Report{
+		Started:  started,
+		Finished: time.Now(),
+		Summary:  summary,
+		Tracks:   tracks,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	return os.WriteFile(file, data, 0644)
+}
+
+// keep overwrites dst unless the newer value is empty.
+func keep(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+func writeLine(v interface{}) {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	events.Write(append(line, '\n'))
+}
@@ -1,5 +1,7 @@
 package structs
 
+import "sync"
+
 type ConfigSet struct {
 	Storefront              string `yaml:"storefront"` 
 	MediaUserToken          string `yaml:"media-user-token"`
@@ -8,6 +10,8 @@ type ConfigSet struct {
 	SaveLrcFile             bool   `yaml:"save-lrc-file"`
 	LrcType                 string `yaml:"lrc-type"`
 	LrcFormat               string `yaml:"lrc-format"`
+	LrcTranslations         string `yaml:"lrc-translations"`
+	LrcLanguages            []string `yaml:"lrc-languages"`
 	SaveAnimatedArtwork     bool   `yaml:"save-animated-artwork"`
 	EmbyAnimatedArtwork     bool   `yaml:"emby-animated-artwork"`
 	EmbedLrc                bool   `yaml:"embed-lrc"`
@@ -38,22 +42,53 @@ type ConfigSet struct {
 	DlAlbumcoverForPlaylist bool   `yaml:"dl-albumcover-for-playlist"`
 	MVAudioType             string `yaml:"mv-audio-type"`
 	MVMax                   int    `yaml:"mv-max"`
+	MVSubtitles             string `yaml:"mv-subtitles"`
 	ConvertAfterDownload       bool   `yaml:"convert-after-download"`
 	ConvertFormat              string `yaml:"convert-format"`
 	ConvertKeepOriginal        bool   `yaml:"convert-keep-original"`
 	ConvertSkipIfSourceMatch   bool   `yaml:"convert-skip-if-source-matches"`
 	FFmpegPath                 string `yaml:"ffmpeg-path"`
 	ConvertExtraArgs           string `yaml:"convert-extra-args"`
+	FFprobePath                string `yaml:"ffprobe-path"`
+	VerifyDownloads            bool   `yaml:"verify-downloads"`
+	VerifyTolerance            int    `yaml:"verify-tolerance"`
+	VerifyRetries              int    `yaml:"verify-retries"`
 	ConvertWarnLossyToLossless bool   `yaml:"convert-warn-lossy-to-lossless"`
 	ConvertSkipLossyToLossless bool   `yaml:"convert-skip-lossy-to-lossless"`
+	CatalogBaseURL             string `yaml:"catalog-base-url"`
+	WebBaseURL                 string `yaml:"web-base-url"`
+	FixtureDir                 string `yaml:"fixture-dir"`
+	FixtureMode                string `yaml:"fixture-mode"`
+	HistoryFile                string `yaml:"history-file"`
+	ManifestFile               string `yaml:"manifest-file"`
+	Jobs                       int    `yaml:"jobs"`
+	ReportFile                 string `yaml:"report-file"`
+	DiscFolderFormat           string `yaml:"disc-folder-format"`
+	SaveDiscPlaylists          bool   `yaml:"save-disc-playlists"`
+	MaxNameBytes               int    `yaml:"max-name-bytes"`
+	MaxPathBytes               int    `yaml:"max-path-bytes"`
+	UnicodeNormalization       string `yaml:"unicode-normalization"`
+	LyricsCacheDir             string `yaml:"lyrics-cache-dir"`
+	LyricsCacheTTL             string `yaml:"lyrics-cache-ttl"`
 }
 
+// Counter tallies track outcomes. Download workers update it through Add;
+// the fields are read directly once the run is over.
 type Counter struct {
 	Unavailable int
 	NotSong     int
 	Error       int
 	Success     int
 	Total       int
+
+	mu sync.Mutex
+}
+
+// Add increases the given field of c by n, e.g. c.Add(&c.Success, 1).
+func (c *Counter) Add(field *int, n int) {
+	c.mu.Lock()
+	*field += n
+	c.mu.Unlock()
 }
 
 // 艺术家页面
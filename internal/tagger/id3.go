@@ -0,0 +1,232 @@
+package tagger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"main/internal/metadata"
+)
+
+// ID3 writes an ID3v2.4 tag in front of .mp3 files. Text frames are UTF-8
+// and multiple artists are separated the v2.4 way, with NUL bytes.
+type ID3 struct{}
+
+// id3Utf8 is the text encoding byte of UTF-8 frames.
+const id3Utf8 = 3
+
+func (ID3) Write(path string, meta *metadata.TrackMetadata) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	old, err := readID3(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	frames := id3Frames(meta)
+	for _, frame := range old {
+		if (frame.id == "APIC" && meta.Picture == nil) ||
+			(frame.id == "USLT" && meta.Lyrics == "") ||
+			(frame.id == "SYLT" && len(meta.SyncedLyrics) == 0) {
+			frames = append(frames, frame.encode()...)
+		}
+	}
+	// leave room so later edits by other tools need no rewrite
+	padding := 2048
+	var header [10]byte
+	copy(header[:], "ID3")
+	header[3] = 4
+	putSyncsafe(header[6:], len(frames)+padding)
+
+	return replaceFile(path, func(out *os.File) error {
+		w := bufio.NewWriter(out)
+		w.Write(header[:])
+		w.Write(frames)
+		w.Write(make([]byte, padding))
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// id3Frames encodes the frames of meta.
+func id3Frames(meta *metadata.TrackMetadata) []byte {
+	var buf bytes.Buffer
+	frame := func(id string, body []byte) {
+		var header [10]byte
+		copy(header[:], id)
+		putSyncsafe(header[4:8], len(body))
+		buf.Write(header[:])
+		buf.Write(body)
+	}
+	text := func(id string, values ...string) {
+		var kept []string
+		for _, v := range values {
+			if v != "" {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			return
+		}
+		frame(id, append([]byte{id3Utf8}, strings.Join(kept, "\x00")...))
+	}
+	userText := func(desc, value string) {
+		if value != "" {
+			frame("TXXX", append([]byte{id3Utf8}, desc+"\x00"+value...))
+		}
+	}
+
+	text("TIT2", meta.Title)
+	text("TPE1", meta.Artists...)
+	text("TPE2", meta.AlbumArtist)
+	text("TALB", meta.Album)
+	text("TCOM", meta.Composers...)
+	text("TCON", meta.Genre)
+	text("TRCK", Position(meta.TrackNumber, meta.TrackTotal))
+	text("TPOS", Position(meta.DiscNumber, meta.DiscTotal))
+	text("TDRC", meta.Date)
+	text("TDRL", meta.ReleaseTime)
+	text("TCOP", meta.Copyright)
+	text("TPUB", meta.Label)
+	text("TSRC", meta.ISRC)
+	userText("UPC", meta.UPC)
+	switch meta.Advisory {
+	case "explicit":
+		userText("ITUNESADVISORY", "1")
+	case "clean":
+		userText("ITUNESADVISORY", "2")
+	}
+
+	if meta.Picture != nil {
+		body := []byte{0} // Latin-1 for the MIME type and empty description
+		body = append(body, pictureMIME(meta.Picture)+"\x00"...)
+		body = append(body, 3, 0) // front cover, no description
+		frame("APIC", append(body, meta.Picture...))
+	}
+	if meta.Lyrics != "" {
+		body := []byte{id3Utf8}
+		body = append(body, "XXX\x00"...) // unknown language, no descriptor
+		frame("USLT", append(body, meta.Lyrics...))
+	}
+	if len(meta.SyncedLyrics) > 0 {
+		body := []byte{id3Utf8}
+		body = append(body, "XXX"...)
+		body = append(body, 2, 1, 0) // milliseconds, lyrics, no descriptor
+		for _, line := range meta.SyncedLyrics {
+			body = append(body, line.Text+"\x00"...)
+			body = binary.BigEndian.AppendUint32(body, uint32(line.Time))
+		}
+		frame("SYLT", body)
+	}
+	return buf.Bytes()
+}
+
+// id3ISRC returns the TSRC frame of the MP3 at path, if it is Latin-1 or
+// UTF-8.
+func id3ISRC(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	frames, _ := readID3(bufio.NewReader(f))
+	for _, frame := range frames {
+		if frame.id == "TSRC" && len(frame.body) > 1 && (frame.body[0] == 0 || frame.body[0] == id3Utf8) {
+			return strings.TrimRight(string(frame.body[1:]), "\x00")
+		}
+	}
+	return ""
+}
+
+// id3Frame is one frame of an ID3v2 tag.
+type id3Frame struct {
+	id   string
+	body []byte
+}
+
+// encode returns the frame as ID3v2.4 writes it.
+func (f id3Frame) encode() []byte {
+	var header [10]byte
+	copy(header[:], f.id)
+	putSyncsafe(header[4:8], len(f.body))
+	return append(header[:], f.body...)
+}
+
+// readID3 reads the ID3v2.3 or v2.4 tag in front of r, if any, and returns
+// its frames. Frames it cannot copy as they are, because the tag is
+// unsynchronised or the frame compressed or encrypted, are left out.
+func readID3(r *bufio.Reader) ([]id3Frame, error) {
+	header, err := r.Peek(10)
+	if err != nil || string(header[:3]) != "ID3" {
+		return nil, nil
+	}
+	version, flags := header[3], header[5]
+	size := syncsafe(header[6:10])
+	r.Discard(10)
+	tag := make([]byte, size)
+	if _, err := io.ReadFull(r, tag); err != nil {
+		return nil, errors.New("truncated ID3 tag")
+	}
+	if flags&0x10 != 0 {
+		r.Discard(10) // footer
+	}
+	if (version != 3 && version != 4) || flags&0x80 != 0 {
+		return nil, nil
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// extended header
+		n := int(binary.BigEndian.Uint32(tag))
+		if version == 4 {
+			n = syncsafe(tag)
+		} else {
+			n += 4
+		}
+		if n > len(tag) {
+			return nil, nil
+		}
+		tag = tag[n:]
+	}
+	var frames []id3Frame
+	for len(tag) >= 10 && tag[0] != 0 {
+		n := int(binary.BigEndian.Uint32(tag[4:8]))
+		if version == 4 {
+			n = syncsafe(tag[4:8])
+		}
+		if n > len(tag)-10 {
+			break
+		}
+		// compression, encryption, grouping and the like, in v2.3 and v2.4
+		format := tag[9] & 0x0f
+		if version == 3 {
+			format = tag[9] & 0xe0
+		}
+		if format == 0 {
+			frames = append(frames, id3Frame{string(tag[:4]), append([]byte{}, tag[10:10+n]...)})
+		}
+		tag = tag[10+n:]
+	}
+	return frames, nil
+}
+
+// syncsafe decodes a 28-bit integer stored in 4 bytes of 7 bits.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+func putSyncsafe(b []byte, n int) {
+	b[0] = byte(n>>21) & 0x7f
+	b[1] = byte(n>>14) & 0x7f
+	b[2] = byte(n>>7) & 0x7f
+	b[3] = byte(n) & 0x7f
+}
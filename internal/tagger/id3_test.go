@@ -0,0 +1,74 @@
+package tagger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestID3RoundTrip(t *testing.T) {
+	meta := fullMeta(t)
+	path := newMP3(t)
+	if err := (ID3{}).Write(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("ID3\x04\x00\x00")) {
+		t.Fatalf("header % x, want an ID3v2.4 tag without flags", data[:6])
+	}
+	list, rest := readMP3(t, path)
+	if !bytes.Equal(rest, audio) {
+		t.Error("audio changed")
+	}
+
+	utf8 := func(s string) []byte { return append([]byte{id3Utf8}, s...) }
+	synced := []byte{id3Utf8, 'X', 'X', 'X', 2, 1, 0}
+	for _, line := range meta.SyncedLyrics {
+		synced = append(synced, line.Text+"\x00"...)
+		synced = binary.BigEndian.AppendUint32(synced, uint32(line.Time))
+	}
+	want := []id3Frame{
+		{"TIT2", utf8("Sóng")},
+		{"TPE1", utf8("Artist A\x00Artist B")},
+		{"TPE2", utf8("Artist A")},
+		{"TALB", utf8("Album")},
+		{"TCOM", utf8("Composer A\x00Composer B")},
+		{"TCON", utf8("Pop")},
+		{"TRCK", utf8("3/12")},
+		{"TPOS", utf8("1/2")},
+		{"TDRC", utf8("2024-05-17")},
+		{"TDRL", utf8("2024-05-17T07:00:00Z")},
+		{"TCOP", utf8("℗ 2024 Label")},
+		{"TPUB", utf8("Label")},
+		{"TSRC", utf8("USUM71234567")},
+		{"TXXX", utf8("UPC\x0000602455555555")},
+		{"TXXX", utf8("ITUNESADVISORY\x001")},
+		{"APIC", append([]byte("\x00image/png\x00\x03\x00"), meta.Picture...)},
+		{"USLT", utf8("XXX\x00" + meta.Lyrics)},
+		{"SYLT", synced},
+	}
+	if len(list) != len(want) {
+		t.Errorf("%d frames, want %d", len(list), len(want))
+	}
+	for i := 0; i < len(list) && i < len(want); i++ {
+		if list[i].id != want[i].id || !bytes.Equal(list[i].body, want[i].body) {
+			t.Errorf("frame %d is %s %.40q, want %s %.40q", i, list[i].id, list[i].body, want[i].id, want[i].body)
+		}
+	}
+
+	// a second write replaces the tag instead of stacking another in front
+	if err := (ID3{}).Write(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	again, rest := readMP3(t, path)
+	if !bytes.Equal(rest, audio) {
+		t.Error("audio changed on the second write")
+	}
+	if len(again) != len(list) {
+		t.Errorf("%d frames after the second write, want %d", len(again), len(list))
+	}
+}
@@ -0,0 +1,130 @@
+package tagger
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"main/internal/metadata"
+	"main/internal/utils"
+
+	"github.com/zhaarey/go-mp4tag"
+)
+
+// MP4 writes iTunes-style atoms into .m4a and .mp4 files.
+type MP4 struct{}
+
+func (MP4) Write(path string, meta *metadata.TrackMetadata) error {
+	t := &mp4tag.MP4Tags{
+		Title:      meta.Title,
+		TitleSort:  meta.Title,
+		Artist:     meta.Artist,
+		ArtistSort: meta.Artist,
+		Custom: map[string]string{
+			"PERFORMER":   meta.Artist,
+			"RELEASETIME": meta.ReleaseTime,
+			"ISRC":        meta.ISRC,
+			"LABEL":       meta.Label,
+			"UPC":         meta.UPC,
+		},
+		Composer:        meta.Composer,
+		ComposerSort:    meta.Composer,
+		CustomGenre:     meta.Genre,
+		Lyrics:          meta.Lyrics,
+		TrackNumber:     int16(meta.TrackNumber),
+		TrackTotal:      int16(meta.TrackTotal),
+		DiscNumber:      int16(meta.DiscNumber),
+		DiscTotal:       int16(meta.DiscTotal),
+		Album:           meta.Album,
+		AlbumSort:       meta.Album,
+		AlbumArtist:     meta.AlbumArtist,
+		AlbumArtistSort: meta.AlbumArtist,
+		Date:            meta.Date,
+		Copyright:       meta.Copyright,
+		Publisher:       meta.Label,
+	}
+
+	if meta.AlbumID != "" {
+		albumID, err := strconv.ParseUint(meta.AlbumID, 10, 32)
+		if err != nil {
+			return err
+		}
+		t.ItunesAlbumID = int32(albumID)
+	}
+	if meta.ArtistID != "" {
+		artistID, err := strconv.ParseUint(meta.ArtistID, 10, 32)
+		if err != nil {
+			return err
+		}
+		t.ItunesArtistID = int32(artistID)
+	}
+
+	switch meta.Advisory {
+	case "explicit":
+		t.ItunesAdvisory = mp4tag.ItunesAdvisoryExplicit
+	case "clean":
+		t.ItunesAdvisory = mp4tag.ItunesAdvisoryClean
+	default:
+		t.ItunesAdvisory = mp4tag.ItunesAdvisoryNone
+	}
+
+	if meta.Picture != nil {
+		t.Pictures = []*mp4tag.MP4Picture{
+			{
+				Data: meta.Picture,
+			},
+		}
+	}
+
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return err
+	}
+	defer mp4.Close()
+	return mp4.Write(t, []string{})
+}
+
+// EmbedLyrics sets the lyrics of the MP4 at path, keeping its other tags.
+// Other formats return ErrUnsupported.
+func EmbedLyrics(path string, lyrics string) error {
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(path, utils.PartExt))) {
+	case ".m4a", ".mp4":
+	default:
+		return ErrUnsupported
+	}
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return err
+	}
+	defer mp4.Close()
+	return mp4.Write(&mp4tag.MP4Tags{Lyrics: lyrics}, []string{})
+}
+
+// mp4ISRC returns the ISRC atom of the MP4 at path.
+func mp4ISRC(path string) string {
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer mp4.Close()
+	tags, err := mp4.Read()
+	if err != nil {
+		return ""
+	}
+	return tags.Custom["ISRC"]
+}
+
+// MP4Cover returns the first embedded cover of the MP4 at path, or nil if it
+// has none or cannot be read.
+func MP4Cover(path string) []byte {
+	mp4, err := mp4tag.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer mp4.Close()
+	tags, err := mp4.Read()
+	if err != nil || len(tags.Pictures) == 0 {
+		return nil
+	}
+	return tags.Pictures[0].Data
+}
@@ -0,0 +1,232 @@
+package tagger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"main/internal/metadata"
+)
+
+// Opus rewrites the OpusTags header of .opus files. The cover is stored as a
+// METADATA_BLOCK_PICTURE comment.
+type Opus struct{}
+
+// oggPage is one page of a single logical Ogg stream.
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	seq        uint32
+	lacing     []byte
+	data       []byte
+}
+
+func (Opus) Write(path string, meta *metadata.TrackMetadata) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	head, err := readOggPage(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if !bytes.HasPrefix(head.data, []byte("OpusHead")) {
+		return fmt.Errorf("%s: not an Opus stream", path)
+	}
+
+	// The comment header spans the following pages and ends its last one.
+	var tags []byte
+	oldPages := 0
+	for done := false; !done; {
+		page, err := readOggPage(r)
+		if err != nil {
+			return fmt.Errorf("%s: reading OpusTags: %w", path, err)
+		}
+		if page.serial != head.serial {
+			return fmt.Errorf("%s: multiplexed Ogg streams are not supported", path)
+		}
+		tags = append(tags, page.data...)
+		oldPages++
+		done = len(page.lacing) > 0 && page.lacing[len(page.lacing)-1] < 255
+	}
+	if !bytes.HasPrefix(tags, []byte("OpusTags")) {
+		return fmt.Errorf("%s: missing OpusTags header", path)
+	}
+	vendor := "amdl"
+	if v, ok := commentVendor(tags[8:]); ok {
+		vendor = v
+	}
+
+	packet := append([]byte("OpusTags"), vorbisComments(vendor, meta, true, keptComments(tags[8:], meta))...)
+	pages := paginate(packet, head.serial, 1)
+	shift := uint32(len(pages) - oldPages)
+
+	return replaceFile(path, func(out *os.File) error {
+		w := bufio.NewWriter(out)
+		writeOggPage(w, head)
+		for _, page := range pages {
+			writeOggPage(w, page)
+		}
+		for {
+			page, err := readOggPage(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			page.seq += shift
+			writeOggPage(w, page)
+		}
+		return w.Flush()
+	})
+}
+
+// opusComments returns the comments of the OpusTags header of the Opus file
+// at path.
+func opusComments(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if _, err := readOggPage(r); err != nil {
+		return nil
+	}
+	var tags []byte
+	for {
+		page, err := readOggPage(r)
+		if err != nil {
+			return nil
+		}
+		tags = append(tags, page.data...)
+		if len(page.lacing) > 0 && page.lacing[len(page.lacing)-1] < 255 {
+			break
+		}
+	}
+	if !bytes.HasPrefix(tags, []byte("OpusTags")) {
+		return nil
+	}
+	return readComments(tags[8:])
+}
+
+// paginate splits a header packet into pages numbered from seq. Only the
+// page completing the packet carries a granule position.
+func paginate(packet []byte, serial uint32, seq uint32) []*oggPage {
+	var lacing []byte
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			lacing = append(lacing, byte(n))
+			break
+		}
+		lacing = append(lacing, 255)
+	}
+	var pages []*oggPage
+	for offset := 0; len(lacing) > 0; seq++ {
+		count := len(lacing)
+		if count > 255 {
+			count = 255
+		}
+		size := 0
+		for _, l := range lacing[:count] {
+			size += int(l)
+		}
+		page := &oggPage{
+			granule: ^uint64(0),
+			serial:  serial,
+			seq:     seq,
+			lacing:  lacing[:count],
+			data:    packet[offset : offset+size],
+		}
+		if offset > 0 {
+			page.headerType = 1 // continued packet
+		}
+		lacing = lacing[count:]
+		offset += size
+		if len(lacing) == 0 {
+			page.granule = 0
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+	var header [27]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated Ogg page")
+		}
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" {
+		return nil, fmt.Errorf("lost Ogg page sync")
+	}
+	page := &oggPage{
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:]),
+		serial:     binary.LittleEndian.Uint32(header[14:]),
+		seq:        binary.LittleEndian.Uint32(header[18:]),
+		lacing:     make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, page.lacing); err != nil {
+		return nil, fmt.Errorf("truncated Ogg page")
+	}
+	size := 0
+	for _, l := range page.lacing {
+		size += int(l)
+	}
+	page.data = make([]byte, size)
+	if _, err := io.ReadFull(r, page.data); err != nil {
+		return nil, fmt.Errorf("truncated Ogg page")
+	}
+	return page, nil
+}
+
+func writeOggPage(w io.Writer, page *oggPage) error {
+	buf := make([]byte, 27, 27+len(page.lacing)+len(page.data))
+	copy(buf, "OggS")
+	buf[5] = page.headerType
+	binary.LittleEndian.PutUint64(buf[6:], page.granule)
+	binary.LittleEndian.PutUint32(buf[14:], page.serial)
+	binary.LittleEndian.PutUint32(buf[18:], page.seq)
+	buf[26] = byte(len(page.lacing))
+	buf = append(buf, page.lacing...)
+	buf = append(buf, page.data...)
+	binary.LittleEndian.PutUint32(buf[22:], oggCRC(buf))
+	_, err := w.Write(buf)
+	return err
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return
+}()
+
+// oggCRC is the page checksum: CRC-32 with polynomial 0x04c11db7, no
+// reflection, computed with the checksum field zeroed.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
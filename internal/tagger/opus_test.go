@@ -0,0 +1,37 @@
+package tagger
+
+import (
+	"bytes"
+	"encoding/base64"
+	"slices"
+	"testing"
+)
+
+func TestOpusRoundTrip(t *testing.T) {
+	meta := fullMeta(t)
+	path := newOpus(t)
+	if err := (Opus{}).Write(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	comments, pages := readOpus(t, path)
+	want := append(vorbisWant(meta.Lyrics), "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(pictureBlock(meta.Picture)))
+	if !slices.Equal(comments, want) {
+		t.Errorf("comments\n%q\nwant\n%q", comments, want)
+	}
+	picture, err := base64.StdEncoding.DecodeString(commentValues(comments, "METADATA_BLOCK_PICTURE")[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	kind, mime, width, height, _, data := parsePicture(t, picture)
+	if kind != 3 || mime != "image/png" || width != 3 || height != 2 || !bytes.Equal(data, meta.Picture) {
+		t.Errorf("picture type %d, %s, %dx%d, %d bytes", kind, mime, width, height, len(data))
+	}
+
+	// the comment header now takes two pages, so the audio page moves down
+	if len(pages) != 1 {
+		t.Fatalf("%d pages after the comment header, want 1", len(pages))
+	}
+	if p := pages[0]; !bytes.Equal(p.data, audio) || p.granule != 960 || p.serial != 7 || p.seq != 3 {
+		t.Errorf("audio page: granule %d, serial %d, sequence %d", p.granule, p.serial, p.seq)
+	}
+}
@@ -8,14 +8,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 
+	"main/internal/report"
 	"main/internal/structs"
-	"main/internal/task"
 	"main/internal/utils"
-
-	"github.com/zhaarey/go-mp4tag"
 )
 
 func WriteCover(sanAlbumFolder, name string, url string, cfg *structs.ConfigSet) (string, error) {
@@ -39,7 +36,7 @@ func WriteCover(sanAlbumFolder, name string, url string, cfg *structs.ConfigSet)
 	}
 	exists, err := utils.FileExists(covPath)
 	if err != nil {
-		fmt.Println("Failed to check if cover exists.")
+		fmt.Fprintln(report.Out, "Failed to check if cover exists.")
 		return "", err
 	}
 	if exists {
@@ -67,39 +64,43 @@ func WriteCover(sanAlbumFolder, name string, url string, cfg *structs.ConfigSet)
 	defer do.Body.Close()
 	if do.StatusCode != http.StatusOK {
 		if cfg.CoverFormat == "original" {
-			fmt.Println("Failed to get cover, falling back to " + ext + " url.")
+			fmt.Fprintln(report.Out, "Failed to get cover, falling back to "+ext+" url.")
 			splitByDot := strings.Split(originalUrl, ".")
 			last := splitByDot[len(splitByDot)-1]
 			fallback := originalUrl[:len(originalUrl)-len(last)] + ext
 			fallback = strings.Replace(fallback, "{w}x{h}", cfg.CoverSize, 1)
-			fmt.Println("Fallback URL:", fallback)
+			fmt.Fprintln(report.Out, "Fallback URL:", fallback)
 			req, err = http.NewRequest("GET", fallback, nil)
 			if err != nil {
-				fmt.Println("Failed to create request for fallback url.")
+				fmt.Fprintln(report.Out, "Failed to create request for fallback url.")
 				return "", err
 			}
 			req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36")
 			do, err = http.DefaultClient.Do(req)
 			if err != nil {
-				fmt.Println("Failed to get cover from fallback url.")
+				fmt.Fprintln(report.Out, "Failed to get cover from fallback url.")
 				return "", err
 			}
 			defer do.Body.Close()
 			if do.StatusCode != http.StatusOK {
-				fmt.Println(fallback)
+				fmt.Fprintln(report.Out, fallback)
 				return "", errors.New(do.Status)
 			}
 		} else {
 			return "", errors.New(do.Status)
 		}
 	}
-	f, err := os.Create(covPath)
+	f, err := os.Create(utils.PartPath(covPath))
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
 	_, err = io.Copy(f, do.Body)
+	f.Close()
 	if err != nil {
+		os.Remove(utils.PartPath(covPath))
+		return "", err
+	}
+	if err := utils.CommitPart(covPath); err != nil {
 		return "", err
 	}
 	return covPath, nil
@@ -107,115 +108,5 @@ func WriteCover(sanAlbumFolder, name string, url string, cfg *structs.ConfigSet)
 
 func WriteLyrics(sanAlbumFolder, filename string, lrc string) error {
 	lyricspath := filepath.Join(sanAlbumFolder, filename)
-	f, err := os.Create(lyricspath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	_, err = f.WriteString(lrc)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func WriteMP4Tags(track *task.Track, lrc string, cfg *structs.ConfigSet) error {
-	t := &mp4tag.MP4Tags{
-		Title:      track.Resp.Attributes.Name,
-		TitleSort:  track.Resp.Attributes.Name,
-		Artist:     track.Resp.Attributes.ArtistName,
-		ArtistSort: track.Resp.Attributes.ArtistName,
-		Custom: map[string]string{
-			"PERFORMER":   track.Resp.Attributes.ArtistName,
-			"RELEASETIME": track.Resp.Attributes.ReleaseDate,
-			"ISRC":        track.Resp.Attributes.Isrc,
-			"LABEL":       "",
-			"UPC":         "",
-		},
-		Composer:     track.Resp.Attributes.ComposerName,
-		ComposerSort: track.Resp.Attributes.ComposerName,
-		CustomGenre:  track.Resp.Attributes.GenreNames[0],
-		Lyrics:       lrc,
-		TrackNumber:  int16(track.Resp.Attributes.TrackNumber),
-		DiscNumber:   int16(track.Resp.Attributes.DiscNumber),
-		Album:        track.Resp.Attributes.AlbumName,
-		AlbumSort:    track.Resp.Attributes.AlbumName,
-	}
-
-	if track.PreType == "albums" {
-		albumID, err := strconv.ParseUint(track.PreID, 10, 32)
-		if err != nil {
-			return err
-		}
-		t.ItunesAlbumID = int32(albumID)
-	}
-
-	if len(track.Resp.Relationships.Artists.Data) > 0 {
-		artistID, err := strconv.ParseUint(track.Resp.Relationships.Artists.Data[0].ID, 10, 32)
-		if err != nil {
-			return err
-		}
-		t.ItunesArtistID = int32(artistID)
-	}
-
-	if (track.PreType == "playlists" || track.PreType == "stations") && !cfg.UseSongInfoForPlaylist {
-		t.DiscNumber = 1
-		t.DiscTotal = 1
-		t.TrackNumber = int16(track.TaskNum)
-		t.TrackTotal = int16(track.TaskTotal)
-		t.Album = track.PlaylistData.Attributes.Name
-		t.AlbumSort = track.PlaylistData.Attributes.Name
-		t.AlbumArtist = track.PlaylistData.Attributes.ArtistName
-		t.AlbumArtistSort = track.PlaylistData.Attributes.ArtistName
-	} else if (track.PreType == "playlists" || track.PreType == "stations") && cfg.UseSongInfoForPlaylist {
-		t.DiscTotal = int16(track.DiscTotal)
-		t.TrackTotal = int16(track.AlbumData.Attributes.TrackCount)
-		t.AlbumArtist = track.AlbumData.Attributes.ArtistName
-		t.AlbumArtistSort = track.AlbumData.Attributes.ArtistName
-		t.Custom["UPC"] = track.AlbumData.Attributes.Upc
-		t.Custom["LABEL"] = track.AlbumData.Attributes.RecordLabel
-		t.Date = track.AlbumData.Attributes.ReleaseDate
-		t.Copyright = track.AlbumData.Attributes.Copyright
-		t.Publisher = track.AlbumData.Attributes.RecordLabel
-	} else {
-		t.DiscTotal = int16(track.DiscTotal)
-		t.TrackTotal = int16(track.AlbumData.Attributes.TrackCount)
-		t.AlbumArtist = track.AlbumData.Attributes.ArtistName
-		t.AlbumArtistSort = track.AlbumData.Attributes.ArtistName
-		t.Custom["UPC"] = track.AlbumData.Attributes.Upc
-		t.Date = track.AlbumData.Attributes.ReleaseDate
-		t.Copyright = track.AlbumData.Attributes.Copyright
-		t.Publisher = track.AlbumData.Attributes.RecordLabel
-	}
-
-	switch track.Resp.Attributes.ContentRating {
-	case "explicit":
-		t.ItunesAdvisory = mp4tag.ItunesAdvisoryExplicit
-	case "clean":
-		t.ItunesAdvisory = mp4tag.ItunesAdvisoryClean
-	default:
-		t.ItunesAdvisory = mp4tag.ItunesAdvisoryNone
-	}
-
-	if track.CoverPath != "" {
-		data, err := os.ReadFile(track.CoverPath)
-		if err == nil {
-			t.Pictures = []*mp4tag.MP4Picture{
-				{
-					Data: data,
-				},
-			}
-		}
-	}
-
-	mp4, err := mp4tag.Open(track.SavePath)
-	if err != nil {
-		return err
-	}
-	defer mp4.Close()
-	err = mp4.Write(t, []string{})
-	if err != nil {
-		return err
-	}
-	return nil
+	return utils.WriteFile(lyricspath, []byte(lrc))
 }
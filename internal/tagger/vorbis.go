@@ -0,0 +1,334 @@
+package tagger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"main/internal/metadata"
+)
+
+// FLAC writes Vorbis comments and a PICTURE block into .flac files.
+type FLAC struct{}
+
+// FLAC metadata block types.
+const (
+	flacStreamInfo    = 0
+	flacPadding       = 1
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+func (FLAC) Write(path string, meta *metadata.TrackMetadata) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if err := skipID3(r); err != nil {
+		return err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return fmt.Errorf("%s: not a FLAC file", path)
+	}
+
+	var blocks []flacBlock
+	var pictures []flacBlock
+	var kept []string
+	vendor := "amdl"
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return fmt.Errorf("%s: truncated metadata: %w", path, err)
+		}
+		last = header[0]&0x80 != 0
+		kind := header[0] & 0x7f
+		data := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("%s: truncated metadata: %w", path, err)
+		}
+		switch kind {
+		case flacVorbisComment:
+			if v, ok := commentVendor(data); ok {
+				vendor = v
+			}
+			kept = keptComments(data, meta)
+		case flacPicture:
+			pictures = append(pictures, flacBlock{kind, data})
+		case flacPadding:
+		default:
+			blocks = append(blocks, flacBlock{kind, data})
+		}
+	}
+	if len(blocks) == 0 || blocks[0].kind != flacStreamInfo {
+		return fmt.Errorf("%s: missing STREAMINFO", path)
+	}
+
+	blocks = append(blocks, flacBlock{flacVorbisComment, vorbisComments(vendor, meta, false, kept)})
+	if meta.Picture != nil {
+		blocks = append(blocks, flacBlock{flacPicture, pictureBlock(meta.Picture)})
+	} else {
+		blocks = append(blocks, pictures...)
+	}
+	// leave room so later edits by other tools need no rewrite
+	blocks = append(blocks, flacBlock{flacPadding, make([]byte, 4096)})
+
+	return replaceFile(path, func(out *os.File) error {
+		w := bufio.NewWriter(out)
+		w.WriteString("fLaC")
+		for i, b := range blocks {
+			if len(b.data) > 0xffffff {
+				return fmt.Errorf("FLAC metadata block too large (%d bytes)", len(b.data))
+			}
+			kind := b.kind
+			if i == len(blocks)-1 {
+				kind |= 0x80
+			}
+			w.Write([]byte{kind, byte(len(b.data) >> 16), byte(len(b.data) >> 8), byte(len(b.data))})
+			w.Write(b.data)
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// vorbisFields lists the comments of meta in writing order.
+func vorbisFields(meta *metadata.TrackMetadata) [][2]string {
+	var fields [][2]string
+	add := func(key, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{key, value})
+		}
+	}
+	add("TITLE", meta.Title)
+	for _, artist := range meta.Artists {
+		add("ARTIST", artist)
+	}
+	add("ALBUMARTIST", meta.AlbumArtist)
+	add("ALBUM", meta.Album)
+	for _, composer := range meta.Composers {
+		add("COMPOSER", composer)
+	}
+	add("GENRE", meta.Genre)
+	if meta.TrackNumber > 0 {
+		add("TRACKNUMBER", strconv.Itoa(meta.TrackNumber))
+	}
+	if meta.TrackTotal > 0 {
+		add("TRACKTOTAL", strconv.Itoa(meta.TrackTotal))
+	}
+	if meta.DiscNumber > 0 {
+		add("DISCNUMBER", strconv.Itoa(meta.DiscNumber))
+	}
+	if meta.DiscTotal > 0 {
+		add("DISCTOTAL", strconv.Itoa(meta.DiscTotal))
+	}
+	add("DATE", meta.Date)
+	add("RELEASETIME", meta.ReleaseTime)
+	add("COPYRIGHT", meta.Copyright)
+	add("LABEL", meta.Label)
+	add("UPC", meta.UPC)
+	add("ISRC", meta.ISRC)
+	switch meta.Advisory {
+	case "explicit":
+		add("ITUNESADVISORY", "1")
+	case "clean":
+		add("ITUNESADVISORY", "2")
+	}
+	add("LYRICS", meta.Lyrics)
+	return fields
+}
+
+// vorbisComments builds a comment header body from meta and the comments
+// kept from the old header. Opus stores the cover as a
+// METADATA_BLOCK_PICTURE comment; FLAC has its own block for it.
+func vorbisComments(vendor string, meta *metadata.TrackMetadata, withPicture bool, kept []string) []byte {
+	var buf bytes.Buffer
+	putString := func(s string) {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	fields := vorbisFields(meta)
+	count := len(fields) + len(kept)
+	if withPicture && meta.Picture != nil {
+		count++
+	}
+	putString(vendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(count))
+	for _, field := range fields {
+		putString(field[0] + "=" + field[1])
+	}
+	for _, comment := range kept {
+		putString(comment)
+	}
+	if withPicture && meta.Picture != nil {
+		putString("METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(pictureBlock(meta.Picture)))
+	}
+	return buf.Bytes()
+}
+
+// commentVendor returns the vendor string of a comment header body.
+func commentVendor(data []byte) (string, bool) {
+	if len(data) < 4 {
+		return "", false
+	}
+	n := binary.LittleEndian.Uint32(data)
+	if uint64(n) > uint64(len(data)-4) {
+		return "", false
+	}
+	return string(data[4 : 4+n]), true
+}
+
+// readComments returns the comments of a comment header body, as far as
+// they can be read.
+func readComments(data []byte) []string {
+	next := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", false
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, true
+	}
+	if _, ok := next(); !ok || len(data) < 4 {
+		return nil
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var comments []string
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			break
+		}
+		comments = append(comments, c)
+	}
+	return comments
+}
+
+// comment returns the first value of key in comments.
+func comment(comments []string, key string) string {
+	for _, c := range comments {
+		if k, v, ok := strings.Cut(c, "="); ok && strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// flacComments returns the Vorbis comments of the FLAC at path.
+func flacComments(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if skipID3(r) != nil {
+		return nil
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return nil
+	}
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil
+		}
+		last = header[0]&0x80 != 0
+		data := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil
+		}
+		if header[0]&0x7f == flacVorbisComment {
+			return readComments(data)
+		}
+	}
+	return nil
+}
+
+// keptComments returns the comments of the header body data that meta has
+// nothing to replace with: the lyrics and the cover. A retag without lyrics
+// or cover at hand so keeps those of the file.
+func keptComments(data []byte, meta *metadata.TrackMetadata) []string {
+	var kept []string
+	for _, c := range readComments(data) {
+		key, _, _ := strings.Cut(c, "=")
+		switch strings.ToUpper(key) {
+		case "LYRICS", "UNSYNCEDLYRICS":
+			if meta.Lyrics == "" {
+				kept = append(kept, c)
+			}
+		case "METADATA_BLOCK_PICTURE":
+			if meta.Picture == nil {
+				kept = append(kept, c)
+			}
+		}
+	}
+	return kept
+}
+
+// pictureBlock encodes a FLAC PICTURE block holding a front cover.
+func pictureBlock(data []byte) []byte {
+	var width, height, depth uint32
+	if cfg, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = uint32(cfg.Width), uint32(cfg.Height)
+		depth = 24
+		if format == "png" {
+			depth = 32
+		}
+	}
+	mime := pictureMIME(data)
+	var buf bytes.Buffer
+	put := func(v uint32) { binary.Write(&buf, binary.BigEndian, v) }
+	put(3) // front cover
+	put(uint32(len(mime)))
+	buf.WriteString(mime)
+	put(0) // description
+	put(width)
+	put(height)
+	put(depth)
+	put(0) // colors, only for indexed images
+	put(uint32(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// skipID3 drops an ID3v2 tag some tools put in front of FLAC streams.
+func skipID3(r *bufio.Reader) error {
+	header, err := r.Peek(10)
+	if err != nil || string(header[:3]) != "ID3" {
+		return nil
+	}
+	size := syncsafe(header[6:10]) + 10
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	if _, err := r.Discard(size); err != nil {
+		return errors.New("truncated ID3 tag")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package tagger
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+// vorbisWant lists the comments fullMeta is written as.
+func vorbisWant(lyrics string) []string {
+	return []string{
+		"TITLE=Sóng",
+		"ARTIST=Artist A",
+		"ARTIST=Artist B",
+		"ALBUMARTIST=Artist A",
+		"ALBUM=Album",
+		"COMPOSER=Composer A",
+		"COMPOSER=Composer B",
+		"GENRE=Pop",
+		"TRACKNUMBER=3",
+		"TRACKTOTAL=12",
+		"DISCNUMBER=1",
+		"DISCTOTAL=2",
+		"DATE=2024-05-17",
+		"RELEASETIME=2024-05-17T07:00:00Z",
+		"COPYRIGHT=℗ 2024 Label",
+		"LABEL=Label",
+		"UPC=00602455555555",
+		"ISRC=USUM71234567",
+		"ITUNESADVISORY=1",
+		"LYRICS=" + lyrics,
+	}
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	meta := fullMeta(t)
+	path := newFLAC(t)
+	if err := (FLAC{}).Write(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	blocks, rest := readFLAC(t, path)
+	if !bytes.Equal(rest, audio) {
+		t.Error("audio changed")
+	}
+	var kinds []byte
+	for _, b := range blocks {
+		kinds = append(kinds, b.kind)
+	}
+	if want := []byte{flacStreamInfo, flacVorbisComment, flacPicture, flacPadding}; !bytes.Equal(kinds, want) {
+		t.Fatalf("blocks %v, want %v", kinds, want)
+	}
+	if !bytes.Equal(blocks[0].data, make([]byte, 34)) {
+		t.Error("STREAMINFO changed")
+	}
+	if vendor, _ := commentVendor(blocks[1].data); vendor != "amdl" {
+		t.Errorf("vendor %q, want amdl", vendor)
+	}
+	if got, want := readComments(blocks[1].data), vorbisWant(meta.Lyrics); !slices.Equal(got, want) {
+		t.Errorf("comments\n%q\nwant\n%q", got, want)
+	}
+	kind, mime, width, height, depth, data := parsePicture(t, blocks[2].data)
+	if kind != 3 || mime != "image/png" || width != 3 || height != 2 || depth != 32 || !bytes.Equal(data, meta.Picture) {
+		t.Errorf("picture type %d, %s, %dx%d, depth %d, %d bytes", kind, mime, width, height, depth, len(data))
+	}
+
+	// a second write replaces the tags instead of adding to them
+	if err := (FLAC{}).Write(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	again, rest := readFLAC(t, path)
+	if !bytes.Equal(rest, audio) {
+		t.Error("audio changed on the second write")
+	}
+	if len(again) != len(blocks) || !bytes.Equal(again[1].data, blocks[1].data) || !bytes.Equal(again[2].data, blocks[2].data) {
+		t.Error("a second write changed the tags")
+	}
+}
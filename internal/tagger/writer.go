@@ -0,0 +1,107 @@
+package tagger
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"main/internal/metadata"
+	"main/internal/utils"
+)
+
+// ErrUnsupported is returned for files no Tagger can write.
+var ErrUnsupported = errors.New("unsupported file format for tagging")
+
+// Tagger writes the tags of meta into the file at path, replacing the ones
+// already there. The cover and lyrics of the file are kept when meta has
+// none, so a retag without them does not strip them.
+type Tagger interface {
+	Write(path string, meta *metadata.TrackMetadata) error
+}
+
+// For returns the Tagger for the extension of path. A part file is tagged
+// like the file it becomes.
+func For(path string) (Tagger, error) {
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(path, utils.PartExt))) {
+	case ".m4a", ".mp4":
+		return MP4{}, nil
+	case ".flac":
+		return FLAC{}, nil
+	case ".opus":
+		return Opus{}, nil
+	case ".mp3":
+		return ID3{}, nil
+	}
+	return nil, ErrUnsupported
+}
+
+// Write tags the file at path with the Tagger matching its extension.
+func Write(path string, meta *metadata.TrackMetadata) error {
+	t, err := For(path)
+	if err != nil {
+		return err
+	}
+	return t.Write(path, meta)
+}
+
+// ISRC returns the ISRC tag of the file at path, or "" if it has none or
+// cannot be read.
+func ISRC(path string) string {
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(path, utils.PartExt))) {
+	case ".m4a", ".mp4":
+		return mp4ISRC(path)
+	case ".flac":
+		return comment(flacComments(path), "ISRC")
+	case ".opus":
+		return comment(opusComments(path), "ISRC")
+	case ".mp3":
+		return id3ISRC(path)
+	}
+	return ""
+}
+
+// Position formats a track or disc position as "n" or "n/total", or "" if
+// n is not known.
+func Position(n, total int) string {
+	if n == 0 {
+		return ""
+	}
+	if total == 0 {
+		return strconv.Itoa(n)
+	}
+	return strconv.Itoa(n) + "/" + strconv.Itoa(total)
+}
+
+// pictureMIME guesses the MIME type of a cover from its first bytes.
+func pictureMIME(data []byte) string {
+	if len(data) > 8 && string(data[1:4]) == "PNG" {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// replaceFile writes a new copy of path through write and moves it over the
+// original, so a failed write never leaves a half-tagged file behind.
+func replaceFile(path string, write func(f *os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,360 @@
+package tagger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"main/internal/metadata"
+)
+
+// audio stands for the encoded audio after the headers; the writers must
+// copy it untouched.
+var audio = []byte("\xff\xf1audio frames that must survive tagging")
+
+// newFLAC writes a FLAC file with only a STREAMINFO block before audio.
+func newFLAC(t *testing.T) string {
+	t.Helper()
+	var b bytes.Buffer
+	b.WriteString("fLaC")
+	b.Write([]byte{0x80 | flacStreamInfo, 0, 0, 34})
+	b.Write(make([]byte, 34))
+	b.Write(audio)
+	path := filepath.Join(t.TempDir(), "song.flac")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readFLAC returns the metadata blocks of the FLAC at path and the bytes
+// after them.
+func readFLAC(t *testing.T, path string) ([]flacBlock, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:4]) != "fLaC" {
+		t.Fatalf("%s does not start with fLaC", path)
+	}
+	data = data[4:]
+	var blocks []flacBlock
+	for last := false; !last; {
+		if len(data) < 4 {
+			t.Fatal("truncated FLAC metadata")
+		}
+		last = data[0]&0x80 != 0
+		n := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		blocks = append(blocks, flacBlock{data[0] & 0x7f, data[4 : 4+n]})
+		data = data[4+n:]
+	}
+	return blocks, data
+}
+
+// newOpus writes an Opus file with an OpusHead page, an OpusTags page and
+// one audio page.
+func newOpus(t *testing.T) string {
+	t.Helper()
+	var b bytes.Buffer
+	writeOggPage(&b, &oggPage{headerType: 2, serial: 7, lacing: []byte{19}, data: append([]byte("OpusHead"), make([]byte, 11)...)})
+	tags := append([]byte("OpusTags"), vorbisComments("test", &metadata.TrackMetadata{Title: strings.Repeat("x", 300)}, false, nil)...)
+	for _, page := range paginate(tags, 7, 1) {
+		writeOggPage(&b, page)
+	}
+	writeOggPage(&b, &oggPage{granule: 960, serial: 7, seq: 2, lacing: []byte{byte(len(audio))}, data: audio})
+	path := filepath.Join(t.TempDir(), "song.opus")
+	if err := os.WriteFile(path, b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readOpus returns the comments of the Opus file at path and its pages
+// after the comment header.
+func readOpus(t *testing.T, path string) ([]string, []*oggPage) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var pages []*oggPage
+	for {
+		page, err := readOggPage(r)
+		if err != nil {
+			break
+		}
+		var check bytes.Buffer
+		writeOggPage(&check, page)
+		raw := check.Bytes()
+		sum := binary.LittleEndian.Uint32(raw[22:])
+		binary.LittleEndian.PutUint32(raw[22:], 0)
+		if oggCRC(raw) != sum {
+			t.Errorf("page %d has a bad checksum", page.seq)
+		}
+		pages = append(pages, page)
+	}
+	var tags []byte
+	i := 1
+	for ; i < len(pages); i++ {
+		tags = append(tags, pages[i].data...)
+		if l := pages[i].lacing; len(l) > 0 && l[len(l)-1] < 255 {
+			break
+		}
+	}
+	if !bytes.HasPrefix(tags, []byte("OpusTags")) {
+		t.Fatal("no OpusTags header")
+	}
+	return readComments(tags[8:]), pages[i+1:]
+}
+
+// newMP3 writes an MP3 file without tags.
+func newMP3(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	if err := os.WriteFile(path, audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readMP3 returns the frames of the ID3 tag of the MP3 at path and the
+// bytes after it.
+func readMP3(t *testing.T, path string) ([]id3Frame, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	frames, err := readID3(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest := new(bytes.Buffer)
+	rest.ReadFrom(r)
+	return frames, bytes.TrimLeft(rest.Bytes(), "\x00")
+}
+
+// fullMeta returns metadata with every field the writers know set, with
+// lyrics that take the Opus comment header over two pages.
+func fullMeta(t *testing.T) *metadata.TrackMetadata {
+	t.Helper()
+	var cover bytes.Buffer
+	if err := png.Encode(&cover, image.NewRGBA(image.Rect(0, 0, 3, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return &metadata.TrackMetadata{
+		Title:        "Sóng",
+		Artists:      []string{"Artist A", "Artist B"},
+		AlbumArtist:  "Artist A",
+		Album:        "Album",
+		Composers:    []string{"Composer A", "Composer B"},
+		Genre:        "Pop",
+		TrackNumber:  3,
+		TrackTotal:   12,
+		DiscNumber:   1,
+		DiscTotal:    2,
+		Date:         "2024-05-17",
+		ReleaseTime:  "2024-05-17T07:00:00Z",
+		Copyright:    "℗ 2024 Label",
+		Label:        "Label",
+		UPC:          "00602455555555",
+		ISRC:         "USUM71234567",
+		Advisory:     "explicit",
+		Lyrics:       strings.Repeat("[00:01.00]la la\n", 5000),
+		SyncedLyrics: []metadata.SyncedLine{{Time: 1000, Text: "la"}, {Time: 2500, Text: "la la"}},
+		Picture:      cover.Bytes(),
+	}
+}
+
+// parsePicture decodes a FLAC PICTURE block.
+func parsePicture(t *testing.T, block []byte) (kind uint32, mime string, width, height, depth uint32, data []byte) {
+	t.Helper()
+	next := func() uint32 {
+		if len(block) < 4 {
+			t.Fatal("truncated PICTURE block")
+		}
+		v := binary.BigEndian.Uint32(block)
+		block = block[4:]
+		return v
+	}
+	bytesOf := func() []byte {
+		n := next()
+		if uint64(n) > uint64(len(block)) {
+			t.Fatal("truncated PICTURE block")
+		}
+		b := block[:n]
+		block = block[n:]
+		return b
+	}
+	kind = next()
+	mime = string(bytesOf())
+	bytesOf() // description
+	width, height, depth = next(), next(), next()
+	next() // colors
+	data = bytesOf()
+	if len(block) != 0 {
+		t.Errorf("%d bytes after the PICTURE data", len(block))
+	}
+	return
+}
+
+func commentValues(comments []string, key string) []string {
+	var values []string
+	for _, c := range comments {
+		if k, v, _ := strings.Cut(c, "="); strings.EqualFold(k, key) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func frames(list []id3Frame, id string) [][]byte {
+	var bodies [][]byte
+	for _, f := range list {
+		if f.id == id {
+			bodies = append(bodies, f.body)
+		}
+	}
+	return bodies
+}
+
+// TestKeepsCoverAndLyrics retags files that have a cover and lyrics with
+// metadata that has neither, as retag does without embed-cover or a
+// media-user-token, and then with a new cover.
+func TestKeepsCoverAndLyrics(t *testing.T) {
+	cover := []byte("\xff\xd8\xff\xe0 old cover")
+	newCover := []byte("\xff\xd8\xff\xe0 new cover")
+	tagged := &metadata.TrackMetadata{Title: "Song", Picture: cover, Lyrics: "[00:01.00]la la"}
+	retag := &metadata.TrackMetadata{Title: "Song (Remastered)"}
+	recovered := &metadata.TrackMetadata{Title: "Song", Picture: newCover}
+
+	t.Run("flac", func(t *testing.T) {
+		path := newFLAC(t)
+		pictures := func() [][]byte {
+			blocks, rest := readFLAC(t, path)
+			if !bytes.Equal(rest, audio) {
+				t.Fatal("audio changed")
+			}
+			var list [][]byte
+			for _, b := range blocks {
+				if b.kind == flacPicture {
+					list = append(list, b.data)
+				}
+			}
+			return list
+		}
+		lyrics := func() []string {
+			blocks, _ := readFLAC(t, path)
+			for _, b := range blocks {
+				if b.kind == flacVorbisComment {
+					return commentValues(readComments(b.data), "LYRICS")
+				}
+			}
+			return nil
+		}
+		for _, meta := range []*metadata.TrackMetadata{tagged, retag} {
+			if err := (FLAC{}).Write(path, meta); err != nil {
+				t.Fatal(err)
+			}
+			if p := pictures(); len(p) != 1 || !bytes.Equal(p[0], pictureBlock(cover)) {
+				t.Errorf("after writing %q the file has %d pictures, want the old cover", meta.Title, len(p))
+			}
+			if l := lyrics(); len(l) != 1 || l[0] != tagged.Lyrics {
+				t.Errorf("after writing %q the lyrics are %q", meta.Title, l)
+			}
+		}
+		if err := (FLAC{}).Write(path, recovered); err != nil {
+			t.Fatal(err)
+		}
+		if p := pictures(); len(p) != 1 || !bytes.Equal(p[0], pictureBlock(newCover)) {
+			t.Errorf("new cover: the file has %d pictures, want only the new one", len(p))
+		}
+	})
+
+	t.Run("opus", func(t *testing.T) {
+		path := newOpus(t)
+		for _, meta := range []*metadata.TrackMetadata{tagged, retag} {
+			if err := (Opus{}).Write(path, meta); err != nil {
+				t.Fatal(err)
+			}
+			comments, _ := readOpus(t, path)
+			if p := commentValues(comments, "METADATA_BLOCK_PICTURE"); len(p) != 1 || p[0] != base64.StdEncoding.EncodeToString(pictureBlock(cover)) {
+				t.Errorf("after writing %q the file has %d pictures, want the old cover", meta.Title, len(p))
+			}
+			if l := commentValues(comments, "LYRICS"); len(l) != 1 || l[0] != tagged.Lyrics {
+				t.Errorf("after writing %q the lyrics are %q", meta.Title, l)
+			}
+		}
+		if err := (Opus{}).Write(path, recovered); err != nil {
+			t.Fatal(err)
+		}
+		comments, _ := readOpus(t, path)
+		if p := commentValues(comments, "METADATA_BLOCK_PICTURE"); len(p) != 1 || p[0] != base64.StdEncoding.EncodeToString(pictureBlock(newCover)) {
+			t.Errorf("new cover: the file has %d pictures, want only the new one", len(p))
+		}
+	})
+
+	t.Run("mp3", func(t *testing.T) {
+		path := newMP3(t)
+		for _, meta := range []*metadata.TrackMetadata{tagged, retag} {
+			if err := (ID3{}).Write(path, meta); err != nil {
+				t.Fatal(err)
+			}
+			list, rest := readMP3(t, path)
+			if !bytes.Equal(rest, audio) {
+				t.Fatal("audio changed")
+			}
+			if p := frames(list, "APIC"); len(p) != 1 || !bytes.HasSuffix(p[0], cover) {
+				t.Errorf("after writing %q the file has %d APIC frames, want the old cover", meta.Title, len(p))
+			}
+			if l := frames(list, "USLT"); len(l) != 1 || !bytes.HasSuffix(l[0], []byte(tagged.Lyrics)) {
+				t.Errorf("after writing %q the file has %d USLT frames", meta.Title, len(l))
+			}
+		}
+		if err := (ID3{}).Write(path, recovered); err != nil {
+			t.Fatal(err)
+		}
+		list, _ := readMP3(t, path)
+		if p := frames(list, "APIC"); len(p) != 1 || !bytes.HasSuffix(p[0], newCover) {
+			t.Errorf("new cover: the file has %d APIC frames, want only the new one", len(p))
+		}
+	})
+}
+
+func TestISRC(t *testing.T) {
+	meta := &metadata.TrackMetadata{Title: "Song", ISRC: "USUM71234567"}
+	tests := []struct {
+		name   string
+		path   string
+		tagger Tagger
+	}{
+		{"flac", newFLAC(t), FLAC{}},
+		{"opus", newOpus(t), Opus{}},
+		{"mp3", newMP3(t), ID3{}},
+	}
+	for _, tt := range tests {
+		if got := ISRC(tt.path); got != "" {
+			t.Errorf("%s: ISRC of an untagged file = %q", tt.name, got)
+		}
+		if err := tt.tagger.Write(tt.path, meta); err != nil {
+			t.Fatal(err)
+		}
+		if got := ISRC(tt.path); got != meta.ISRC {
+			t.Errorf("%s: ISRC = %q, want %q", tt.name, got, meta.ISRC)
+		}
+	}
+	if got := ISRC(filepath.Join(t.TempDir(), "missing.m4a")); got != "" {
+		t.Errorf("ISRC of a missing file = %q", got)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/olekukonko/tablewriter"
 
 	"main/internal/api"
+	"main/internal/report"
 )
 
 type Playlist struct {
@@ -102,7 +103,7 @@ func (a *Playlist) ShowSelect() []int {
 			track.Type})
 
 	}
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(report.Out)
 	table.SetHeader([]string{"", "Track Name", "Rating", "Type"})
 	//table.SetFooter([]string{"", "", "Footer", "Footer4"})
 	table.SetRowLine(false)
@@ -136,17 +137,17 @@ func (a *Playlist) ShowSelect() []int {
 	}
 	//table.AppendBulk(data)
 	table.Render()
-	fmt.Println("Please select from the track options above (multiple options separated by commas, ranges supported, or type 'all' to select all)")
+	fmt.Fprintln(report.Out, "Please select from the track options above (multiple options separated by commas, ranges supported, or type 'all' to select all)")
 	cyanColor := color.New(color.FgCyan)
-	cyanColor.Print("select: ")
+	cyanColor.Fprint(report.Out, "select: ")
 	reader := bufio.NewReader(os.Stdin)
 	input, err := reader.ReadString('\n')
 	if err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(report.Out, err)
 	}
 	input = strings.TrimSpace(input)
 	if input == "all" {
-		fmt.Println("You have selected all options:")
+		fmt.Fprintln(report.Out, "You have selected all options:")
 		selected = arr
 	} else {
 		selectedOptions := [][]string{}
@@ -164,24 +165,24 @@ func (a *Playlist) ShowSelect() []int {
 			if len(opt) == 1 { // Single option
 				num, err := strconv.Atoi(opt[0])
 				if err != nil {
-					fmt.Println("Invalid option:", opt[0])
+					fmt.Fprintln(report.Out, "Invalid option:", opt[0])
 					continue
 				}
 				if num > 0 && num <= len(arr) {
 					selected = append(selected, num)
 					//args = append(args, urls[num-1])
 				} else {
-					fmt.Println("Option out of range:", opt[0])
+					fmt.Fprintln(report.Out, "Option out of range:", opt[0])
 				}
 			} else if len(opt) == 2 { // Range
 				start, err1 := strconv.Atoi(opt[0])
 				end, err2 := strconv.Atoi(opt[1])
 				if err1 != nil || err2 != nil {
-					fmt.Println("Invalid range:", opt)
+					fmt.Fprintln(report.Out, "Invalid range:", opt)
 					continue
 				}
 				if start < 1 || end > len(arr) || start > end {
-					fmt.Println("Range out of range:", opt)
+					fmt.Fprintln(report.Out, "Range out of range:", opt)
 					continue
 				}
 				for i := start; i <= end; i++ {
@@ -189,7 +190,7 @@ func (a *Playlist) ShowSelect() []int {
 					selected = append(selected, i)
 				}
 			} else {
-				fmt.Println("Invalid option:", opt)
+				fmt.Fprintln(report.Out, "Invalid option:", opt)
 			}
 		}
 	}
@@ -13,6 +13,7 @@ import (
 	//"github.com/olekukonko/tablewriter"
 
 	"main/internal/api"
+	"main/internal/report"
 )
 
 type Station struct {
@@ -63,7 +64,7 @@ func (a *Station) GetResp(mutoken, token, l string) error {
 	for i, trackData := range tracksResp.Data {
 		albumResp, err := api.GetAlbumRespByHref(trackData.Href, a.Language, token)
 		if err != nil {
-			fmt.Println("Error getting album response:", err)
+			fmt.Fprintln(report.Out, "Error getting album response:", err)
 			continue
 		}
 		albumLen := len(albumResp.Data[0].Relationships.Tracks.Data)
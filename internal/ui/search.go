@@ -5,6 +5,8 @@ import (
 	"strings"
 
 	"main/internal/api"
+	"main/internal/report"
+	"main/internal/utils"
 
 	"github.com/AlecAivazis/survey/v2"
 	// Assuming config struct is needed or passed
@@ -12,17 +14,31 @@ import (
 
 // SearchResultItem is a unified struct to hold search results for display.
 type SearchResultItem struct {
-	Type   string
-	Name   string
-	Detail string
-	URL    string
-	ID     string
+	Type        string
+	Name        string
+	Detail      string
+	URL         string
+	ID          string
+	AudioTraits []string
 }
 
-// QualityOption holds information about a downloadable quality.
+// QualityOption holds information about a downloadable quality. Trait is the
+// audioTraits entry that must be advertised for the option to be offered.
 type QualityOption struct {
 	ID          string
 	Description string
+	Trait       string
+	AACType     string
+	AlacMax     int
+}
+
+var qualities = []QualityOption{
+	{ID: "alac", Description: "Hi-Res Lossless (ALAC, up to 192kHz)", Trait: "hi-res-lossless", AlacMax: 192000},
+	{ID: "alac", Description: "Lossless (ALAC, up to 48kHz)", Trait: "lossless", AlacMax: 48000},
+	{ID: "atmos", Description: "Dolby Atmos", Trait: "atmos"},
+	{ID: "aac", Description: "High-Quality (AAC-LC 256Kbps)", AACType: "aac-lc"},
+	{ID: "aac", Description: "AAC Binaural", Trait: "atmos", AACType: "aac-binaural"},
+	{ID: "aac", Description: "AAC Downmix", Trait: "atmos", AACType: "aac-downmix"},
 }
 
 // Global flags (to be returned or managed via a config object)
@@ -32,58 +48,77 @@ type QualityOption struct {
 
 type SearchSelection struct {
 	URL     string
-	Quality string // "alac", "aac", "atmos"
-	AACType string // "aac" (implied if quality is aac)
+	Quality string // "alac", "aac", "atmos"; empty keeps the download flags
+	AACType string // set with "aac"
+	AlacMax int    // set with "alac"
 }
 
-// PromptForQuality asks the user to select a download quality for the chosen media.
-func PromptForQuality(item SearchResultItem) (string, error) {
+// availableQualities returns the options item advertises. Items without
+// audioTraits get every option.
+func availableQualities(item SearchResultItem) []QualityOption {
+	if len(item.AudioTraits) == 0 {
+		return qualities
+	}
+	var options []QualityOption
+	for _, q := range qualities {
+		if q.Trait == "" || utils.Contains(item.AudioTraits, q.Trait) {
+			options = append(options, q)
+		}
+	}
+	return options
+}
+
+// PromptForQuality asks the user to select a download quality for the chosen
+// media. A nil option with a nil error means the prompt was cancelled; artists
+// get an empty option so the download flags apply.
+func PromptForQuality(item SearchResultItem) (*QualityOption, error) {
 	if item.Type == "Artist" {
-		fmt.Println("Artist selected. Proceeding to list all albums/videos.")
-		return "default", nil
+		fmt.Fprintln(report.Out, "Artist selected. Proceeding to list all albums/videos.")
+		return &QualityOption{}, nil
 	}
 
-	fmt.Printf("\nFetching available qualities for: %s\n", item.Name)
+	fmt.Fprintf(report.Out, "\nAvailable qualities for: %s\n", item.Name)
 
-	qualities := []QualityOption{
-		{ID: "alac", Description: "Lossless (ALAC)"},
-		{ID: "aac", Description: "High-Quality (AAC)"},
-		{ID: "atmos", Description: "Dolby Atmos"},
-	}
+	options := availableQualities(item)
 	qualityOptions := []string{}
-	for _, q := range qualities {
+	for _, q := range options {
 		qualityOptions = append(qualityOptions, q.Description)
 	}
 
 	prompt := &survey.Select{
 		Message:  "Select a quality to download:",
 		Options:  qualityOptions,
-		PageSize: 5,
+		PageSize: len(qualityOptions),
 	}
 
 	selectedIndex := 0
 	err := survey.AskOne(prompt, &selectedIndex)
 	if err != nil {
-		return "", nil
+		return nil, nil
 	}
 
-	return qualities[selectedIndex].ID, nil
+	return &options[selectedIndex], nil
 }
 
-// HandleSearch manages the entire interactive search process.
-func HandleSearch(searchType string, queryParts []string, token string, storefront string, lang string) (*SearchSelection, error) {
+// HandleSearch manages the entire interactive search process. A pick above
+// zero takes that result (1-based, across pages) without prompting and leaves
+// Quality empty, so the download flags decide.
+func HandleSearch(searchType string, queryParts []string, token string, storefront string, lang string, pick int) (*SearchSelection, error) {
 	query := strings.Join(queryParts, " ")
 	validTypes := map[string]bool{"album": true, "song": true, "artist": true}
 	if !validTypes[searchType] {
 		return nil, fmt.Errorf("invalid search type: %s. Use 'album', 'song', or 'artist'", searchType)
 	}
 
-	fmt.Printf("Searching for %ss: \"%s\" in storefront \"%s\"\n", searchType, query, storefront)
+	fmt.Fprintf(report.Out, "Searching for %ss: \"%s\" in storefront \"%s\"\n", searchType, query, storefront)
 
 	offset := 0
 	limit := 15
 
 	apiSearchType := searchType + "s"
+	if pick > 0 {
+		offset = (pick - 1) / limit * limit
+	}
 
 	for {
 		searchResp, err := api.Search(storefront, query, apiSearchType, lang, token, limit, offset)
@@ -113,7 +148,7 @@ func HandleSearch(searchType string, queryParts []string, token string, storefro
 					trackInfo := fmt.Sprintf("%d tracks", item.Attributes.TrackCount)
 					detail := fmt.Sprintf("%s (%s, %s)", item.Attributes.ArtistName, year, trackInfo)
 					displayOptions = append(displayOptions, fmt.Sprintf("%s - %s", item.Attributes.Name, detail))
-					items = append(items, SearchResultItem{Type: "Album", URL: item.Attributes.URL, ID: item.ID})
+					items = append(items, SearchResultItem{Type: "Album", Name: item.Attributes.Name, URL: item.Attributes.URL, ID: item.ID, AudioTraits: item.Attributes.AudioTraits})
 				}
 				hasNext = searchResp.Results.Albums.Next != ""
 			}
@@ -122,7 +157,7 @@ func HandleSearch(searchType string, queryParts []string, token string, storefro
 				for _, item := range searchResp.Results.Songs.Data {
 					detail := fmt.Sprintf("%s (%s)", item.Attributes.ArtistName, item.Attributes.AlbumName)
 					displayOptions = append(displayOptions, fmt.Sprintf("%s - %s", item.Attributes.Name, detail))
-					items = append(items, SearchResultItem{Type: "Song", URL: item.Attributes.URL, ID: item.ID})
+					items = append(items, SearchResultItem{Type: "Song", Name: item.Attributes.Name, URL: item.Attributes.URL, ID: item.ID, AudioTraits: item.Attributes.AudioTraits})
 				}
 				hasNext = searchResp.Results.Songs.Next != ""
 			}
@@ -134,17 +169,26 @@ func HandleSearch(searchType string, queryParts []string, token string, storefro
 						detail = strings.Join(item.Attributes.GenreNames, ", ")
 					}
 					displayOptions = append(displayOptions, fmt.Sprintf("%s (%s)", item.Attributes.Name, detail))
-					items = append(items, SearchResultItem{Type: "Artist", URL: item.Attributes.URL, ID: item.ID})
+					items = append(items, SearchResultItem{Type: "Artist", Name: item.Attributes.Name, URL: item.Attributes.URL, ID: item.ID})
 				}
 				hasNext = searchResp.Results.Artists.Next != ""
 			}
 		}
 
 		if len(items) == 0 && offset == 0 {
-			fmt.Println("No results found.")
+			fmt.Fprintln(report.Out, "No results found.")
 			return nil, nil
 		}
 
+		if pick > 0 {
+			idx := pick - 1 - offset
+			if idx >= len(items) {
+				return nil, fmt.Errorf("search pick %d is out of range", pick)
+			}
+			fmt.Fprintf(report.Out, "Picked %s\n", items[idx].URL)
+			return &SearchSelection{URL: items[idx].URL}, nil
+		}
+
 		if hasNext {
 			displayOptions = append(displayOptions, nextPageOpt)
 		}
@@ -183,14 +227,16 @@ func HandleSearch(searchType string, queryParts []string, token string, storefro
 		if err != nil {
 			return nil, fmt.Errorf("could not process quality selection: %w", err)
 		}
-		if quality == "" {
-			fmt.Println("Selection cancelled.")
+		if quality == nil {
+			fmt.Fprintln(report.Out, "Selection cancelled.")
 			return nil, nil
 		}
 
 		return &SearchSelection{
 			URL:     selectedItem.URL,
-			Quality: quality,
+			Quality: quality.ID,
+			AACType: quality.AACType,
+			AlacMax: quality.AlacMax,
 		}, nil
 	}
 }
@@ -8,18 +8,21 @@ import (
 	"strings"
 
 	"main/internal/api"
+	"main/internal/report"
+	"main/internal/utils"
 
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
 )
 
 // SelectArtistItems displays artist items and prompts user for selection.
-func SelectArtistItems(items []api.ArtistItem, relationship string) ([]string, error) {
+// A non-empty spec (e.g. "all" or "1-3,7") is used instead of the prompt.
+func SelectArtistItems(items []api.ArtistItem, relationship string, spec string) ([]string, error) {
 	var args []string
 	var urls []string
 	var options [][]string
 
-	table := tablewriter.NewWriter(os.Stdout)
+	table := tablewriter.NewWriter(report.Out)
 	switch relationship {
 	case "albums":
 		table.SetHeader([]string{"", "Album Name", "Date", "Album ID"})
@@ -49,15 +52,27 @@ func SelectArtistItems(items []api.ArtistItem, relationship string) ([]string, e
 	// The original checkArtist had `if artist_select { return urls, nil }` where `artist_select` was a global.
 	// We'll leave that decision to the caller or allow passing a "selectAll" boolean.
 
+	if spec != "" {
+		selected, err := utils.ParseSelection(spec, len(items))
+		if err != nil {
+			return nil, err
+		}
+		for _, num := range selected {
+			args = append(args, urls[num-1])
+		}
+		fmt.Fprintf(report.Out, "Selected %d of %d %s.\n", len(args), len(items), relationship)
+		return args, nil
+	}
+
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Please select from the " + relationship + " options above (multiple options separated by commas, ranges supported, or type 'all' to select all)")
+	fmt.Fprintln(report.Out, "Please select from the "+relationship+" options above (multiple options separated by commas, ranges supported, or type 'all' to select all)")
 	cyanColor := color.New(color.FgCyan)
-	cyanColor.Print("Enter your choice: ")
+	cyanColor.Fprint(report.Out, "Enter your choice: ")
 	input, _ := reader.ReadString('\n')
 
 	input = strings.TrimSpace(input)
 	if input == "all" {
-		fmt.Println("You have selected all options:")
+		fmt.Fprintln(report.Out, "You have selected all options:")
 		return urls, nil
 	}
 
@@ -72,37 +87,37 @@ func SelectArtistItems(items []api.ArtistItem, relationship string) ([]string, e
 		}
 	}
 
-	fmt.Println("You have selected the following options:")
+	fmt.Fprintln(report.Out, "You have selected the following options:")
 	for _, opt := range selectedOptions {
 		if len(opt) == 1 {
 			num, err := strconv.Atoi(opt[0])
 			if err != nil {
-				fmt.Println("Invalid option:", opt[0])
+				fmt.Fprintln(report.Out, "Invalid option:", opt[0])
 				continue
 			}
 			if num > 0 && num <= len(options) {
-				fmt.Println(options[num-1])
+				fmt.Fprintln(report.Out, options[num-1])
 				args = append(args, urls[num-1])
 			} else {
-				fmt.Println("Option out of range:", opt[0])
+				fmt.Fprintln(report.Out, "Option out of range:", opt[0])
 			}
 		} else if len(opt) == 2 {
 			start, err1 := strconv.Atoi(opt[0])
 			end, err2 := strconv.Atoi(opt[1])
 			if err1 != nil || err2 != nil {
-				fmt.Println("Invalid range:", opt)
+				fmt.Fprintln(report.Out, "Invalid range:", opt)
 				continue
 			}
 			if start < 1 || end > len(options) || start > end {
-				fmt.Println("Range out of range:", opt)
+				fmt.Fprintln(report.Out, "Range out of range:", opt)
 				continue
 			}
 			for i := start; i <= end; i++ {
-				fmt.Println(options[i-1])
+				fmt.Fprintln(report.Out, options[i-1])
 				args = append(args, urls[i-1])
 			}
 		} else {
-			fmt.Println("Invalid option:", opt)
+			fmt.Fprintln(report.Out, "Invalid option:", opt)
 		}
 	}
 	return args, nil
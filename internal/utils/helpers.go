@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 )
 
 func LimitString(s string, limit int) string {
@@ -38,3 +41,45 @@ func FileExists(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// ParseSelection turns a selection such as "1-3,7", "all" or "none" into
+// 1-based indexes out of total. Unlike the interactive prompts it rejects
+// anything it cannot parse instead of skipping it.
+func ParseSelection(spec string, total int) ([]int, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "none":
+		return []int{}, nil
+	case "all":
+		selected := make([]int, total)
+		for i := range selected {
+			selected[i] = i + 1
+		}
+		return selected, nil
+	}
+	selected := []int{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(strings.TrimSpace(first))
+		if err != nil {
+			return nil, fmt.Errorf("invalid option: %q", part)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(strings.TrimSpace(last))
+			if err != nil {
+				return nil, fmt.Errorf("invalid range: %q", part)
+			}
+		}
+		if start < 1 || end > total || start > end {
+			return nil, fmt.Errorf("option out of range: %q (1-%d)", part, total)
+		}
+		for i := start; i <= end; i++ {
+			if !IsInArray(selected, i) {
+				selected = append(selected, i)
+			}
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PartExt marks a file that is still being written. Files are written under
+// PartPath and renamed once complete, so an interrupted run never leaves a
+// file behind that looks finished.
+const PartExt = ".part"
+
+// SetAsideExt marks a file found broken that waits for its replacement.
+// CommitPart removes it once the new file is in place, so the old file is
+// kept if the download fails.
+const SetAsideExt = ".broken"
+
+// PartPath returns the name path is written under until it is complete.
+func PartPath(path string) string {
+	return path + PartExt
+}
+
+// SetAsidePath returns the name a broken path is kept under until it is
+// replaced.
+func SetAsidePath(path string) string {
+	return path + SetAsideExt
+}
+
+// CommitPart renames the part file of path to path and removes the file path
+// replaces, if it was set aside.
+func CommitPart(path string) error {
+	if err := os.Rename(PartPath(path), path); err != nil {
+		os.Remove(PartPath(path))
+		return err
+	}
+	os.Remove(SetAsidePath(path))
+	return nil
+}
+
+// CopyPart copies the file at path to its part file, to be changed there
+// and put in place with CommitPart. The part file is removed on failure.
+func CopyPart(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(PartPath(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(PartPath(path))
+	}
+	return err
+}
+
+// WriteFile writes data to path through its part file.
+func WriteFile(path string, data []byte) error {
+	if err := os.WriteFile(PartPath(path), data, 0644); err != nil {
+		os.Remove(PartPath(path))
+		return err
+	}
+	return CommitPart(path)
+}
+
+// StalePartAge is how long a part file has not been written to before it
+// counts as left behind. A run that is still writing a part keeps it
+// younger than that.
+const StalePartAge = 24 * time.Hour
+
+// RemoveStaleParts deletes the part files below the given folders that were
+// last written more than age ago, left behind by a run that was killed, and
+// returns how many it removed. Younger parts may belong to a run still going
+// and are kept. Folders that do not exist are skipped.
+func RemoveStaleParts(age time.Duration, roots ...string) int {
+	removed := 0
+	for _, root := range roots {
+		if root == "" {
+			continue
+		}
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() || !strings.HasSuffix(d.Name(), PartExt) {
+				return nil
+			}
+			if info, err := d.Info(); err != nil || time.Since(info.ModTime()) < age {
+				return nil
+			}
+			if os.Remove(path) == nil {
+				removed++
+			}
+			return nil
+		})
+	}
+	return removed
+}
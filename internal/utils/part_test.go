@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveStaleParts(t *testing.T) {
+	root := t.TempDir()
+	old := time.Now().Add(-2 * StalePartAge)
+	tests := []struct {
+		name  string
+		mtime time.Time
+		kept  bool
+	}{
+		{"Album/01. Old.m4a.part", old, false},
+		{"Album/02. Writing.m4a.part", time.Now(), true},
+		{"Album/03. Done.m4a", old, true},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(root, filepath.FromSlash(tt.name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, tt.mtime, tt.mtime)
+	}
+	if n := RemoveStaleParts(StalePartAge, root, "", filepath.Join(root, "missing")); n != 1 {
+		t.Errorf("RemoveStaleParts = %d, want 1", n)
+	}
+	for _, tt := range tests {
+		if _, err := os.Stat(filepath.Join(root, filepath.FromSlash(tt.name))); (err == nil) != tt.kept {
+			t.Errorf("%s kept = %v, want %v", tt.name, err == nil, tt.kept)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cover.jpg")
+	if err := WriteFile(path, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("ReadFile = %q, %v", data, err)
+	}
+	if _, err := os.Stat(PartPath(path)); !os.IsNotExist(err) {
+		t.Errorf("part file left behind: %v", err)
+	}
+}
+
+func TestCopyPart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	os.WriteFile(path, []byte("tagged"), 0644)
+	if err := CopyPart(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(PartPath(path)); err != nil || string(data) != "tagged" {
+		t.Errorf("part file = %q, %v", data, err)
+	}
+	if err := CopyPart(filepath.Join(filepath.Dir(path), "missing.m4a")); err == nil {
+		t.Error("CopyPart of a missing file succeeded")
+	}
+}
@@ -0,0 +1,236 @@
+// Package verify checks that a written file is complete and playable before
+// it is counted as downloaded.
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"main/internal/utils"
+
+	"github.com/itouakirai/mp4ff/mp4"
+)
+
+var (
+	// ErrNoProbe is returned by Probe when ffprobe cannot be run.
+	ErrNoProbe = errors.New("ffprobe not found")
+	// ErrDuration is wrapped by the errors of files whose duration is off.
+	ErrDuration = errors.New("duration differs from the catalog")
+)
+
+// File checks the file at path with MP4 or, for the formats ffmpeg writes,
+// with Probe. expected is the duration from the catalog; 0 skips comparing
+// durations.
+func File(path string, expected time.Duration, tolerance time.Duration, ffprobe string) error {
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(path, utils.PartExt))) {
+	case ".m4a", ".mp4":
+		return MP4(path, expected, tolerance)
+	}
+	return Probe(ffprobe, path, expected, tolerance)
+}
+
+// MP4 parses the MP4 at path and checks that its audio track has samples,
+// that their tables agree with each other and with the media data, and that
+// they add up to expected within tolerance. Both plain and fragmented files
+// are accepted.
+func MP4(path string, expected time.Duration, tolerance time.Duration) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	parsed, err := mp4.DecodeFile(f, mp4.WithDecodeMode(mp4.DecModeLazyMdat))
+	if err != nil {
+		return fmt.Errorf("corrupt MP4: %w", err)
+	}
+	if parsed.Moov == nil {
+		return fmt.Errorf("corrupt MP4: no moov box")
+	}
+	trak := audioTrak(parsed.Moov)
+	if trak == nil || trak.Tkhd == nil || trak.Mdia.Mdhd == nil || trak.Mdia.Mdhd.Timescale == 0 {
+		return fmt.Errorf("no audio track")
+	}
+	timescale := uint64(trak.Mdia.Mdhd.Timescale)
+
+	var samples, units uint64
+	if parsed.IsFragmented() {
+		samples, units, err = fragmentSamples(parsed, trak.Tkhd.TrackID, info.Size())
+	} else {
+		samples, units, err = tableSamples(parsed, trak, info.Size())
+	}
+	if err != nil {
+		return err
+	}
+	if samples == 0 {
+		return fmt.Errorf("no audio samples")
+	}
+	return checkDuration(time.Duration(units*uint64(time.Second)/timescale), expected, tolerance)
+}
+
+// audioTrak returns the first sound track of moov.
+func audioTrak(moov *mp4.MoovBox) *mp4.TrakBox {
+	for _, trak := range moov.Traks {
+		if trak.Mdia != nil && trak.Mdia.Hdlr != nil && trak.Mdia.Hdlr.HandlerType == "soun" {
+			return trak
+		}
+	}
+	return nil
+}
+
+// tableSamples counts the samples and time units of a plain MP4 track from
+// its sample tables.
+func tableSamples(parsed *mp4.File, trak *mp4.TrakBox, fileSize int64) (samples uint64, units uint64, err error) {
+	if trak.Mdia.Minf == nil || trak.Mdia.Minf.Stbl == nil {
+		return 0, 0, fmt.Errorf("corrupt MP4: no sample table")
+	}
+	stbl := trak.Mdia.Minf.Stbl
+	if stbl.Stsz == nil || stbl.Stts == nil {
+		return 0, 0, fmt.Errorf("corrupt MP4: incomplete sample table")
+	}
+	var timed uint64
+	for i, count := range stbl.Stts.SampleCount {
+		timed += uint64(count)
+		units += uint64(count) * uint64(stbl.Stts.SampleTimeDelta[i])
+	}
+	samples = uint64(stbl.Stsz.SampleNumber)
+	if timed != samples {
+		return 0, 0, fmt.Errorf("corrupt MP4: %d samples have sizes but %d have durations", samples, timed)
+	}
+	size := uint64(stbl.Stsz.SampleUniformSize) * samples
+	for _, s := range stbl.Stsz.SampleSize {
+		size += uint64(s)
+	}
+	if parsed.Mdat == nil {
+		return 0, 0, fmt.Errorf("corrupt MP4: no media data")
+	}
+	if have := mdatBytes(parsed.Mdat, fileSize); size > have {
+		return 0, 0, fmt.Errorf("truncated: samples need %d bytes, media data has %d", size, have)
+	}
+	return samples, units, nil
+}
+
+// fragmentSamples counts the samples and time units of track in the
+// fragments of a fragmented MP4.
+func fragmentSamples(parsed *mp4.File, track uint32, fileSize int64) (samples uint64, units uint64, err error) {
+	var trex *mp4.TrexBox
+	if parsed.Moov.Mvex != nil {
+		for _, t := range parsed.Moov.Mvex.Trexs {
+			if t.TrackID == track {
+				trex = t
+			}
+		}
+	}
+	for _, seg := range parsed.Segments {
+		for _, frag := range seg.Fragments {
+			if frag.Moof == nil {
+				continue
+			}
+			var size uint64
+			for _, traf := range frag.Moof.Trafs {
+				if traf.Tfhd == nil || traf.Tfhd.TrackID != track {
+					continue
+				}
+				dur := traf.Tfhd.DefaultSampleDuration
+				if dur == 0 && trex != nil {
+					dur = trex.DefaultSampleDuration
+				}
+				for _, trun := range traf.Truns {
+					samples += uint64(trun.SampleCount())
+					units += trun.Duration(dur)
+					if trun.HasSampleSize() {
+						size += trun.SizeOfData()
+					}
+				}
+			}
+			if frag.Mdat == nil {
+				return 0, 0, fmt.Errorf("truncated: fragment without media data")
+			}
+			if have := mdatBytes(frag.Mdat, fileSize); size > have {
+				return 0, 0, fmt.Errorf("truncated: fragment samples need %d bytes, media data has %d", size, have)
+			}
+		}
+	}
+	return samples, units, nil
+}
+
+// mdatBytes returns the payload bytes of mdat that are in the file. The box
+// is read lazily, so its header may claim more than a truncated file holds.
+func mdatBytes(mdat *mp4.MdatBox, fileSize int64) uint64 {
+	if !mdat.IsLazy() {
+		return mdat.DataLength()
+	}
+	declared := mdat.GetLazyDataSize()
+	start := mdat.StartPos + mdat.Size() - declared
+	if uint64(fileSize) <= start {
+		return 0
+	}
+	if have := uint64(fileSize) - start; have < declared {
+		return have
+	}
+	return declared
+}
+
+// Probe checks the file at path with ffprobe: it must have an audio stream
+// and last expected within tolerance.
+func Probe(ffprobe string, path string, expected time.Duration, tolerance time.Duration) error {
+	if _, err := exec.LookPath(ffprobe); err != nil {
+		return ErrNoProbe
+	}
+	cmd := exec.Command(ffprobe, "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "stream=codec_name:format=duration", "-of", "json", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("ffprobe: %s", msg)
+	}
+	var probe struct {
+		Streams []struct {
+			CodecName string `json:"codec_name"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return fmt.Errorf("ffprobe: %w", err)
+	}
+	if len(probe.Streams) == 0 {
+		return fmt.Errorf("no audio stream")
+	}
+	seconds, err := strconv.ParseFloat(probe.Format.Duration, 64)
+	if err != nil {
+		return fmt.Errorf("ffprobe reports no duration")
+	}
+	return checkDuration(time.Duration(seconds*float64(time.Second)), expected, tolerance)
+}
+
+func checkDuration(got time.Duration, expected time.Duration, tolerance time.Duration) error {
+	if expected <= 0 {
+		return nil
+	}
+	diff := got - expected
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > tolerance {
+		return fmt.Errorf("%w: %s, expected %s", ErrDuration, got.Round(time.Millisecond), expected.Round(time.Millisecond))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itouakirai/mp4ff/aac"
+	"github.com/itouakirai/mp4ff/mp4"
+)
+
+// audioInit returns an init segment with one AAC track at 44.1 kHz.
+func audioInit(t *testing.T) *mp4.InitSegment {
+	t.Helper()
+	init := mp4.CreateEmptyInit()
+	init.AddEmptyTrack(44100, "audio", "und")
+	if err := init.Moov.Trak.SetAACDescriptor(aac.AAClc, 44100); err != nil {
+		t.Fatal(err)
+	}
+	return init
+}
+
+// fragmentedMP4 returns a fragmented AAC file of n samples of 1024 units.
+func fragmentedMP4(t *testing.T, n int) []byte {
+	t.Helper()
+	init := audioInit(t)
+	frag, err := mp4.CreateFragment(1, init.Moov.Trak.Tkhd.TrackID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		frag.AddFullSample(mp4.FullSample{
+			Sample:     mp4.NewSample(mp4.SyncSampleFlags, 1024, 16, 0),
+			DecodeTime: uint64(i) * 1024,
+			Data:       make([]byte, 16),
+		})
+	}
+	var b bytes.Buffer
+	if err := init.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := frag.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// plainMP4 returns an AAC file with sample tables, as MP4Box and ffmpeg
+// write them, of n samples of 1024 units. sized is how many of them the
+// tables give a size.
+func plainMP4(t *testing.T, n int, sized int) []byte {
+	t.Helper()
+	init := audioInit(t)
+	stbl := init.Moov.Trak.Mdia.Minf.Stbl
+	stbl.Stts.SampleCount = []uint32{uint32(n)}
+	stbl.Stts.SampleTimeDelta = []uint32{1024}
+	stbl.Stsz.SampleUniformSize = 16
+	stbl.Stsz.SampleNumber = uint32(sized)
+	var b bytes.Buffer
+	if err := init.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	mdat := &mp4.MdatBox{Data: make([]byte, 16*n)}
+	if err := mdat.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// errAny stands for any error in the tests below.
+var errAny = errors.New("any error")
+
+func TestMP4(t *testing.T) {
+	// 431 samples of 1024 units last just over 10 s
+	valid := fragmentedMP4(t, 431)
+	plain := plainMP4(t, 431, 431)
+	tests := []struct {
+		name     string
+		data     []byte
+		expected time.Duration
+		want     error // nil for a pass, ErrDuration, or errAny
+	}{
+		{"valid", valid, 10 * time.Second, nil},
+		{"no catalog duration", valid, 0, nil},
+		{"too short", valid, 20 * time.Second, ErrDuration},
+		{"truncated", valid[:len(valid)-100], 10 * time.Second, errAny},
+		{"no samples", fragmentedMP4(t, 0), 0, errAny},
+		{"plain", plain, 10 * time.Second, nil},
+		{"plain too long", plain, 5 * time.Second, ErrDuration},
+		{"plain truncated", plain[:len(plain)-100], 10 * time.Second, errAny},
+		{"plain tables disagree", plainMP4(t, 431, 400), 0, errAny},
+		{"not an MP4", []byte("this is not an mp4 file at all"), 0, errAny},
+		{"empty", nil, 0, errAny},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".m4a")
+		if err := os.WriteFile(path, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := File(path, tt.expected, time.Second, "ffprobe")
+		switch {
+		case tt.want == nil && err != nil:
+			t.Errorf("%s: File = %v, want no error", tt.name, err)
+		case tt.want == errAny && err == nil:
+			t.Errorf("%s: File passed, want an error", tt.name)
+		case tt.want == ErrDuration && !errors.Is(err, ErrDuration):
+			t.Errorf("%s: File = %v, want ErrDuration", tt.name, err)
+		}
+	}
+	if err := MP4(filepath.Join(dir, "missing.m4a"), 0, time.Second); err == nil {
+		t.Error("MP4 of a missing file passed")
+	}
+}
+
+func TestProbeWithoutFFprobe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.flac")
+	os.WriteFile(path, []byte("fLaC"), 0644)
+	if err := File(path, 0, time.Second, filepath.Join(t.TempDir(), "no-ffprobe")); !errors.Is(err, ErrNoProbe) {
+		t.Errorf("File = %v, want ErrNoProbe", err)
+	}
+}
+
+func TestCheckDuration(t *testing.T) {
+	tests := []struct {
+		got, expected time.Duration
+		ok            bool
+	}{
+		{10 * time.Second, 10 * time.Second, true},
+		{10 * time.Second, 0, true},
+		{11 * time.Second, 10 * time.Second, true},
+		{9 * time.Second, 10 * time.Second, true},
+		{11*time.Second + time.Millisecond, 10 * time.Second, false},
+		{5 * time.Second, 10 * time.Second, false},
+	}
+	for _, tt := range tests {
+		err := checkDuration(tt.got, tt.expected, time.Second)
+		if (err == nil) != tt.ok || (err != nil && !errors.Is(err, ErrDuration)) {
+			t.Errorf("checkDuration(%v, %v) = %v, want ok %v", tt.got, tt.expected, err, tt.ok)
+		}
+	}
+}